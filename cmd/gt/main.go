@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"gt/internal/controllers"
+	"gt/internal/events"
+	"gt/internal/mail"
 	"gt/internal/middleware"
 	"gt/internal/repository"
+	"gt/internal/security"
 	"gt/internal/services"
+	"gt/internal/storage"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -21,6 +29,41 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid duration in %s: %v", key, err)
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid integer in %s: %v", key, err)
+	}
+	return n
+}
+
+func parseSameSite(v string) http.SameSite {
+	switch v {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
 func main() {
 	dsn := fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
@@ -38,27 +81,142 @@ func main() {
 		log.Fatal("failed to connect to database: ", err)
 	}
 
-	if err := db.AutoMigrate(&repository.User{}, &repository.Session{}, &repository.GameLogin{}, &repository.GameLoginRequest{}, &repository.Achievement{}); err != nil {
+	if err := repository.Migrate(db); err != nil {
 		log.Fatal("failed to migrate database: ", err)
 	}
 
 	userRepo := repository.NewUserRepository(db)
+	gameRepo := repository.NewGameRepository(db)
 	sessionRepo := repository.NewSessionRepository(db)
 	gameLoginRepo := repository.NewGameLoginRepository(db)
 	gameLoginRequestRepo := repository.NewGameLoginRequestRepository(db)
+	gameLoginRefreshTokenRepo := repository.NewGameLoginRefreshTokenRepository(db)
 	achievementRepo := repository.NewAchievementRepository(db)
+	achievementDefinitionRepo := repository.NewAchievementDefinitionRepository(db)
+	attemptRepo := repository.NewAttemptRepository(db)
+	lockRepo := repository.NewLockRepository(db)
+	emailVerificationRepo := repository.NewEmailVerificationRepository(db)
+	passwordResetRepo := repository.NewPasswordResetRepository(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository(db)
+	usernameReservationRepo := repository.NewUsernameReservationRepository(db)
+	webhookDeliveryRepo := repository.NewWebhookDeliveryRepository(db)
+	friendshipRepo := repository.NewFriendshipRepository(db)
+	achievementProgressRepo := repository.NewAchievementProgressRepository(db)
+
+	gameLoginEvents := events.NewBroker(dsn, repository.GameLoginRequestStateChannel)
+	go gameLoginEvents.Run(context.Background())
+
+	secretKey := getEnv("SECRET_KEY", "")
+	if secretKey == "" {
+		log.Print("SECRET_KEY is not set, using a random key; signed links won't survive a restart")
+		secretKey = security.GenerateToken()
+	}
+	signer := security.NewSigner(secretKey)
+	baseURL := strings.TrimSuffix(getEnv("BASE_URL", "http://localhost:8080"), "/")
+
+	var mailer mail.Sender = mail.NewLogSender()
+	if addr := getEnv("SMTP_ADDR", ""); addr != "" {
+		mailer = mail.NewSMTPSender(mail.SMTPConfig{
+			Addr:     addr,
+			From:     getEnv("SMTP_FROM", "GT <noreply@localhost>"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+		})
+	}
 
-	authService := services.NewAuthService(userRepo, sessionRepo)
-	userService := services.NewUserService(userRepo)
-	gameService := services.NewGameService(gameLoginRepo, gameLoginRequestRepo)
-	achievementService := services.NewAchievementService(achievementRepo)
+	// Local uploads are served from /uploads/ with long-lived cache headers.
+	// They stay in web/public/uploads by default, so avatars stored before
+	// UPLOAD_DIR existed are kept.
+	legacyUploadDir := "web/public/uploads"
+	var uploadStorage storage.Storage
+	var localUploads *storage.LocalStorage
+	switch backend := getEnv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		localUploads = storage.NewLocalStorage(getEnv("UPLOAD_DIR", legacyUploadDir), getEnv("UPLOAD_URL", baseURL+"/uploads"))
+		uploadStorage = localUploads
+	case "s3":
+		s3Storage, err := storage.NewS3Storage(storage.S3Config{
+			Endpoint:        getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:          getEnv("S3_REGION", "us-east-1"),
+			Bucket:          getEnv("S3_BUCKET", ""),
+			AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+			PathStyle:       getEnv("S3_PATH_STYLE", "true") == "true",
+			PublicURL:       getEnv("S3_PUBLIC_URL", ""),
+		})
+		if err != nil {
+			log.Fatal("failed to configure S3 storage: ", err)
+		}
+		uploadStorage = s3Storage
+	default:
+		log.Fatalf("unknown STORAGE_BACKEND %q", backend)
+	}
 
-	signupCtrl := controllers.NewSignupController(authService)
+	middleware.ConfigureCookies(middleware.CookieConfig{
+		Secure:   getEnv("COOKIE_SECURE", "true") == "true",
+		SameSite: parseSameSite(getEnv("COOKIE_SAMESITE", "lax")),
+	})
+
+	passwordPolicy := services.PasswordPolicy{
+		MinLength:      getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		RejectBreached: getEnv("PASSWORD_REJECT_BREACHED", "true") == "true",
+	}
+	loginAccountBackoff := services.NewAttemptBackoff(attemptRepo, "login:account", 5, time.Minute, time.Hour, 24*time.Hour)
+	loginIPBackoff := services.NewAttemptBackoff(attemptRepo, "login:ip", 20, time.Minute, time.Hour, 24*time.Hour)
+	twoFactorService := services.NewTwoFactorService(userRepo, recoveryCodeRepo)
+	authService := services.NewAuthService(userRepo, sessionRepo, usernameReservationRepo, services.SessionConfig{
+		IdleTimeout:             getEnvDuration("SESSION_IDLE_TIMEOUT", 24*time.Hour),
+		AbsoluteTimeout:         getEnvDuration("SESSION_ABSOLUTE_TIMEOUT", 7*24*time.Hour),
+		RememberIdleTimeout:     getEnvDuration("SESSION_REMEMBER_IDLE_TIMEOUT", 30*24*time.Hour),
+		RememberAbsoluteTimeout: getEnvDuration("SESSION_REMEMBER_ABSOLUTE_TIMEOUT", 90*24*time.Hour),
+	}, passwordPolicy, loginAccountBackoff, loginIPBackoff, signer, twoFactorService)
+	activateLimiter := services.NewAttemptLimiter(attemptRepo, "activate", 10, 15*time.Minute)
+	gameService := services.NewGameService(gameRepo, gameLoginRepo, gameLoginRequestRepo, gameLoginRefreshTokenRepo, activateLimiter, gameLoginEvents)
+	achievementService := services.NewAchievementService(achievementRepo, achievementDefinitionRepo, achievementProgressRepo)
+	achievementDefinitionService := services.NewAchievementDefinitionService(achievementDefinitionRepo)
+	verificationLimiter := services.NewAttemptLimiter(attemptRepo, "verify-email", 5, time.Hour)
+	verificationService := services.NewEmailVerificationService(userRepo, emailVerificationRepo, mailer, signer, verificationLimiter, baseURL)
+	userService := services.NewUserService(userRepo, sessionRepo, usernameReservationRepo, verificationService, passwordPolicy, services.UserConfig{
+		UsernameCooldown:    getEnvDuration("USERNAME_CHANGE_COOLDOWN", 30*24*time.Hour),
+		UsernameReservation: getEnvDuration("USERNAME_RESERVATION", 90*24*time.Hour),
+	})
+	avatarService := services.NewAvatarService(userRepo, uploadStorage)
+	iconService := services.NewIconService(uploadStorage)
+	accountService := services.NewAccountService(userRepo, sessionRepo, gameRepo, gameLoginRepo, achievementRepo, friendshipRepo, avatarService, getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 14*24*time.Hour))
+	webhookService := services.NewWebhookService(webhookDeliveryRepo)
+	friendService := services.NewFriendService(friendshipRepo)
+	profileService := services.NewProfileService(userRepo, achievementRepo, friendService)
+	passwordResetLimiter := services.NewAttemptLimiter(attemptRepo, "password-reset", 5, time.Hour)
+	passwordResetService := services.NewPasswordResetService(userRepo, passwordResetRepo, sessionRepo, gameLoginRepo, mailer, passwordResetLimiter, passwordPolicy, baseURL)
+
+	janitorConfig := services.JanitorConfig{
+		Interval:  getEnvDuration("JANITOR_INTERVAL", 10*time.Minute),
+		Retention: getEnvDuration("JANITOR_RETENTION", 24*time.Hour),
+		BatchSize: getEnvInt("JANITOR_BATCH_SIZE", 1000),
+	}
+	if janitorConfig.Interval <= 0 {
+		log.Fatal("JANITOR_INTERVAL must be positive")
+	}
+	if janitorConfig.Retention < 0 {
+		log.Fatal("JANITOR_RETENTION must not be negative")
+	}
+	if janitorConfig.BatchSize <= 0 {
+		log.Fatal("JANITOR_BATCH_SIZE must be positive")
+	}
+	janitor := services.NewJanitorService(lockRepo, gameLoginRequestRepo, sessionRepo, gameLoginRefreshTokenRepo, attemptRepo, emailVerificationRepo, passwordResetRepo, usernameReservationRepo, webhookDeliveryRepo, accountService, webhookService, janitorConfig)
+	go janitor.Run(context.Background())
+
+	signupCtrl := controllers.NewSignupController(authService, verificationService)
 	loginCtrl := controllers.NewLoginController(authService)
 	feedCtrl := controllers.NewFeedController(achievementService)
-	gameCtrl := controllers.NewGameController(gameService, userService)
-	profileCtrl := controllers.NewProfileController(authService)
+	gameCtrl := controllers.NewGameController(gameService, userService, avatarService, baseURL)
+	profileCtrl := controllers.NewProfileController(authService, userService, profileService, friendService, avatarService)
 	achievementCtrl := controllers.NewAchievementController(achievementService)
+	oauthCtrl := controllers.NewOAuthController(gameService, baseURL)
+	settingsCtrl := controllers.NewSettingsController(authService, userService, accountService, profileService, avatarService, gameService, twoFactorService)
+	developerCtrl := controllers.NewDeveloperController(gameService, achievementDefinitionService, iconService)
+	verificationCtrl := controllers.NewEmailVerificationController(verificationService)
+	passwordResetCtrl := controllers.NewPasswordResetController(passwordResetService)
 
 	auth := func(next http.HandlerFunc) http.HandlerFunc {
 		return middleware.RequireAuth(authService, next)
@@ -75,6 +233,13 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.Handle("/public/", http.StripPrefix("/public", http.FileServer(http.Dir("web/public"))))
+	// Icons uploaded before /uploads/ existed link to /public/uploads/. They
+	// are served like any other upload rather than by the file server, which
+	// would list the directory and expose uploads still being written.
+	mux.Handle("/public/uploads/", http.StripPrefix("/public/uploads", storage.NewLocalStorage(legacyUploadDir, "").Handler()))
+	if localUploads != nil {
+		mux.Handle("GET /uploads/", http.StripPrefix("/uploads", localUploads.Handler()))
+	}
 
 	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/feed", http.StatusSeeOther)
@@ -84,18 +249,75 @@ func main() {
 	mux.HandleFunc("POST /signup", noAuth(signupCtrl.PostSignup))
 	mux.HandleFunc("GET /login", loginCtrl.GetLogin)
 	mux.HandleFunc("POST /login", loginCtrl.PostLogin)
+	mux.HandleFunc("POST /login/second-factor", loginCtrl.PostLoginSecondFactor)
 
 	mux.HandleFunc("GET /feed", auth(feedCtrl.GetFeed))
 
 	mux.HandleFunc("POST /api/game/login", gameCtrl.CreateGameLoginRequest)
 	mux.HandleFunc("GET /api/game/login", gameCtrl.GetGameLoginState)
+	mux.HandleFunc("GET /api/game/login/events", gameCtrl.GetGameLoginEvents)
+	mux.HandleFunc("POST /api/game/login/cancel", gameCtrl.CancelGameLoginRequest)
 	mux.HandleFunc("GET /api/game/exchange", gameCtrl.ExchangeGameLoginCode)
+	mux.HandleFunc("POST /api/game/refresh", gameCtrl.RefreshGameLogin)
 	mux.HandleFunc("GET /api/game/user", gameLogin(gameCtrl.GetUser))
 	mux.HandleFunc("POST /api/game/achievement", gameLogin(achievementCtrl.AddAchievement))
+	mux.HandleFunc("POST /api/game/achievement/progress", gameLogin(achievementCtrl.ReportProgress))
+	mux.HandleFunc("POST /oauth/device_authorization", oauthCtrl.DeviceAuthorization)
+	mux.HandleFunc("POST /oauth/token", oauthCtrl.Token)
+	mux.HandleFunc("GET /game/activate", optAuth(gameCtrl.GetActivatePage))
+	mux.HandleFunc("POST /game/activate", auth(gameCtrl.PostActivate))
 	mux.HandleFunc("GET /game", optAuth(gameCtrl.GetGameLoginPage))
 	mux.HandleFunc("POST /game", auth(gameCtrl.PostGameLogin))
-	mux.HandleFunc("GET /profile/logout", auth(profileCtrl.Logout))
+	mux.HandleFunc("GET /profile", auth(profileCtrl.GetMyProfile))
+	mux.HandleFunc("POST /profile/logout", auth(profileCtrl.Logout))
+	mux.HandleFunc("GET /u/{username}", optAuth(profileCtrl.GetProfile))
+	mux.HandleFunc("POST /u/{username}/friend", auth(profileCtrl.PostFriend))
+	mux.HandleFunc("POST /u/{username}/unfriend", auth(profileCtrl.PostUnfriend))
+	mux.HandleFunc("GET /api/users/{username}", optAuth(profileCtrl.GetProfileJSON))
+	mux.HandleFunc("GET /forgot-password", passwordResetCtrl.GetForgot)
+	mux.HandleFunc("POST /forgot-password", passwordResetCtrl.PostForgot)
+	mux.HandleFunc("GET /reset-password", passwordResetCtrl.GetReset)
+	mux.HandleFunc("POST /reset-password", passwordResetCtrl.PostReset)
+	mux.HandleFunc("GET /verify-email", verificationCtrl.GetVerifyEmail)
+	mux.HandleFunc("POST /verify-email/resend", auth(verificationCtrl.PostResend))
+
+	mux.HandleFunc("GET /settings", auth(settingsCtrl.GetSettings))
+	mux.HandleFunc("GET /settings/account", auth(settingsCtrl.GetAccount))
+	mux.HandleFunc("POST /settings/account/username", auth(settingsCtrl.PostUsername))
+	mux.HandleFunc("POST /settings/account/email", auth(settingsCtrl.PostEmail))
+	mux.HandleFunc("POST /settings/account/password", auth(settingsCtrl.PostPassword))
+	mux.HandleFunc("POST /settings/account/avatar", auth(settingsCtrl.PostAvatar))
+	mux.HandleFunc("POST /settings/account/avatar/remove", auth(settingsCtrl.PostRemoveAvatar))
+	mux.HandleFunc("POST /settings/account/visibility", auth(settingsCtrl.PostProfileVisibility))
+	mux.HandleFunc("POST /settings/account/export", auth(settingsCtrl.PostExport))
+	mux.HandleFunc("POST /settings/account/delete", auth(settingsCtrl.PostDeleteAccount))
+	mux.HandleFunc("POST /settings/account/delete/cancel", auth(settingsCtrl.PostCancelDeletion))
+	mux.HandleFunc("GET /settings/games", auth(settingsCtrl.GetGames))
+	mux.HandleFunc("POST /settings/games/{id}/revoke", auth(settingsCtrl.PostRevokeGame))
+	mux.HandleFunc("GET /settings/sessions", auth(settingsCtrl.GetSessions))
+	mux.HandleFunc("POST /settings/sessions/others/revoke", auth(settingsCtrl.PostRevokeOtherSessions))
+	mux.HandleFunc("POST /settings/sessions/{id}/revoke", auth(settingsCtrl.PostRevokeSession))
+	mux.HandleFunc("GET /settings/security", auth(settingsCtrl.GetSecurity))
+	mux.HandleFunc("POST /settings/security/totp/setup", auth(settingsCtrl.PostTOTPSetup))
+	mux.HandleFunc("POST /settings/security/totp/enable", auth(settingsCtrl.PostTOTPEnable))
+	mux.HandleFunc("POST /settings/security/totp/disable", auth(settingsCtrl.PostTOTPDisable))
+	mux.HandleFunc("POST /settings/security/recovery-codes", auth(settingsCtrl.PostRecoveryCodes))
+
+	mux.HandleFunc("GET /developer", auth(developerCtrl.GetIndex))
+	mux.HandleFunc("POST /developer/games", auth(developerCtrl.PostGame))
+	mux.HandleFunc("GET /developer/games/{id}", auth(developerCtrl.GetGame))
+	mux.HandleFunc("POST /developer/games/{id}", auth(developerCtrl.PostGameSettings))
+	mux.HandleFunc("POST /developer/games/{id}/secret", auth(developerCtrl.PostClientSecret))
+	mux.HandleFunc("POST /developer/games/{id}/webhook-secret", auth(developerCtrl.PostWebhookSecret))
+	mux.HandleFunc("POST /developer/games/{id}/achievements", auth(developerCtrl.PostAchievement))
+	mux.HandleFunc("POST /developer/games/{id}/achievements/{achievementID}", auth(developerCtrl.PostAchievementSettings))
+	mux.HandleFunc("POST /developer/games/{id}/achievements/{achievementID}/retire", auth(developerCtrl.PostAchievementRetire))
+
+	// Game and OAuth APIs authenticate with client credentials or bearer
+	// tokens, so only the cookie-authenticated pages need CSRF tokens.
+	handler := middleware.CSRF(mux, "/api/", "/oauth/")
+
 	addr := getEnv("LISTEN_ADDR", "localhost:8080")
 	log.Printf("server starting on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	log.Fatal(http.ListenAndServe(addr, handler))
 }
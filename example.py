@@ -1,4 +1,4 @@
-import time
+import os
 import requests
 from pydantic import BaseModel
 from rich.console import Console
@@ -12,6 +12,16 @@ console = Console()
 
 BASE_URL = "http://localhost:8080"
 
+# Register a game at /developer with a "first_login" achievement and pass
+# its credentials in GT_CLIENT_ID and GT_CLIENT_SECRET.
+CLIENT_ID = os.environ.get("GT_CLIENT_ID")
+CLIENT_SECRET = os.environ.get("GT_CLIENT_SECRET")
+if not CLIENT_ID or not CLIENT_SECRET:
+    raise SystemExit(
+        "Set GT_CLIENT_ID and GT_CLIENT_SECRET to the credentials of a game "
+        f"registered at {BASE_URL}/developer with a \"first_login\" achievement"
+    )
+
 
 # ---------- MODELS ----------
 
@@ -19,6 +29,8 @@ class GameLoginResponse(BaseModel):
     id: str
     url: str
     token: str
+    user_code: str
+    activate_url: str
 
 
 class GameUser(BaseModel):
@@ -35,16 +47,25 @@ class GameLogin(BaseModel):
 class GameLoginStateResponse(BaseModel):
     id: str
     user_id: str | None
+    state: str
 
 
 # ---------- API ----------
 
 def create_game_login_request() -> GameLoginResponse:
-    r = requests.post(f"{BASE_URL}/api/game/login")
+    r = requests.post(f"{BASE_URL}/api/game/login", auth=(CLIENT_ID, CLIENT_SECRET))
     r.raise_for_status()
     return GameLoginResponse(**r.json())
 
 
+# Messages for the states a login request can end in other than "approved".
+LOGIN_FAILURES = {
+    "access_denied": "❌ Пользователь отклонил вход",
+    "cancelled": "❌ Вход отменён",
+    "expired": "⌛ Запрос на вход истёк",
+}
+
+
 def wait_for_user_login(id: str, token: str) -> GameLoginStateResponse:
     spinner = Spinner("dots", text="Ожидание входа пользователя…")
 
@@ -53,18 +74,21 @@ def wait_for_user_login(id: str, token: str) -> GameLoginStateResponse:
         refresh_per_second=12,
         console=console,
     ):
-        while True:
-            r = requests.get(
-                f"{BASE_URL}/api/game/login",
-                params={"id": id, "token": token},
-            )
+        # The server pushes a "state" event whenever the request changes,
+        # so there is no need to poll.
+        with requests.get(
+            f"{BASE_URL}/api/game/login/events",
+            params={"id": id, "token": token},
+            stream=True,
+        ) as r:
             r.raise_for_status()
-            state = GameLoginStateResponse(**r.json())
-
-            if state.user_id is not None:
-                return state
-
-            time.sleep(2)
+            for line in r.iter_lines(decode_unicode=True):
+                if line.startswith("data: "):
+                    state = GameLoginStateResponse.model_validate_json(line[len("data: "):])
+                    if state.state != "pending":
+                        return state
+            # The stream ends without a final event only if it was cut off.
+            raise RuntimeError("Соединение с сервером прервано")
 
 
 def exchange_code_for_token(id: str, token: str) -> GameLogin:
@@ -107,6 +131,19 @@ class GameAPI:
         r.raise_for_status()
         return True
 
+    def add_achievement_progress(self, name: str, increment: int) -> dict:
+        params = {"name": name, "increment": increment}
+        r = requests.post(
+            f"{BASE_URL}/api/game/achievement/progress",
+            params=params,
+            headers={
+                "X-Game-Login-ID": self.id,
+                "X-Game-Login-Token": self.token,
+            },
+        )
+        r.raise_for_status()
+        return r.json()
+
 
 # ---------- UI HELPERS ----------
 
@@ -114,6 +151,7 @@ def show_login_info(login: GameLoginResponse):
     table = Table(title="🎮 Game Login", show_header=False)
     table.add_row("Login ID", f"[bold]{login.id}[/bold]")
     table.add_row("URL", f"[cyan]{login.url}[/cyan]")
+    table.add_row("Code", f"[bold yellow]{login.user_code}[/bold yellow] ({login.activate_url})")
 
     console.print(Panel(table, border_style="green"))
 
@@ -147,6 +185,11 @@ if __name__ == "__main__":
 
     state = wait_for_user_login(login.id, login.token)
 
+    if state.state != "approved":
+        message = LOGIN_FAILURES.get(state.state, f"❌ Неизвестное состояние входа: {state.state}")
+        console.print(message, style="bold red")
+        raise SystemExit(1)
+
     console.print("✅ Пользователь вошёл", style="bold green")
 
     game_login = exchange_code_for_token(state.id, login.token)
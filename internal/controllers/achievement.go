@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"gt/internal/middleware"
-	"gt/internal/repository"
 	"gt/internal/services"
 	"net/http"
+	"strconv"
 )
 
 type AchievementController struct {
@@ -14,9 +14,21 @@ type AchievementController struct {
 }
 
 type achievementResponse struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	UserID string `json:"user_id"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Points      int    `json:"points"`
+	UserID      string `json:"user_id"`
+}
+
+type achievementProgressResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Progress    int    `json:"progress"`
+	Target      int    `json:"target"`
+	Unlocked    bool   `json:"unlocked"`
+	// Achievement is only set on the report that unlocked it.
+	Achievement *achievementResponse `json:"achievement,omitempty"`
 }
 
 type achievementErrorResponse struct {
@@ -34,21 +46,27 @@ func (c *AchievementController) jsonResponse(w http.ResponseWriter, data interfa
 }
 
 func (c *AchievementController) AddAchievement(w http.ResponseWriter, r *http.Request) {
-	name := repository.AchievementName(r.FormValue("name"))
-	user := middleware.GameLoginFromContext(r.Context()).User
+	name := r.FormValue("name")
+	gameLogin := middleware.GameLoginFromContext(r.Context())
 	if name == "" {
 		c.jsonResponse(w, achievementErrorResponse{Message: "Name are required"}, http.StatusBadRequest)
 		return
 	}
-	if !name.IsValid() {
+	achievement, err := c.achievementService.CreateAchievement(r.Context(), &services.CreateAchievementRequest{
+		Key:    name,
+		UserID: gameLogin.UserID,
+		GameID: gameLogin.GameID,
+	})
+	if errors.Is(err, services.ErrAchievementDefinitionNotFound) {
 		c.jsonResponse(w, achievementErrorResponse{Message: "Invalid achievement name"}, http.StatusBadRequest)
 		return
-	}
-	achievement, err := c.achievementService.CreateAchievement(r.Context(), &repository.CreateAchievementRequest{
-		Name:   name,
-		UserID: user.ID,
-	})
-	if errors.Is(err, services.ErrAchievementAlreadyExists) {
+	} else if errors.Is(err, services.ErrAchievementDefinitionRetired) {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Achievement is retired"}, http.StatusGone)
+		return
+	} else if errors.Is(err, services.ErrAchievementProgressOnly) {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Achievement unlocks by reporting progress"}, http.StatusBadRequest)
+		return
+	} else if errors.Is(err, services.ErrAchievementAlreadyExists) {
 		c.jsonResponse(w, achievementErrorResponse{Message: "Achievement already exists for user"}, http.StatusConflict)
 		return
 	} else if err != nil {
@@ -56,8 +74,75 @@ func (c *AchievementController) AddAchievement(w http.ResponseWriter, r *http.Re
 		return
 	}
 	c.jsonResponse(w, achievementResponse{
-		ID:     achievement.ID,
-		Name:   achievement.Name,
-		UserID: achievement.UserID,
+		ID:          achievement.ID,
+		Name:        achievement.Definition.Key,
+		DisplayName: achievement.Definition.Name,
+		Points:      achievement.Definition.Points,
+		UserID:      achievement.UserID,
 	}, http.StatusCreated)
 }
+
+// ReportProgress sets the progress of a progress achievement to value, or
+// adds increment to it, and unlocks the achievement once it reaches the
+// target.
+func (c *AchievementController) ReportProgress(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	gameLogin := middleware.GameLoginFromContext(r.Context())
+	if name == "" {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Name are required"}, http.StatusBadRequest)
+		return
+	}
+	value, increment := r.FormValue("value"), r.FormValue("increment")
+	if (value == "") == (increment == "") {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Either value or increment is required"}, http.StatusBadRequest)
+		return
+	}
+	if increment != "" {
+		value = increment
+	}
+	amount, err := strconv.Atoi(value)
+	if err != nil {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Progress must be a number"}, http.StatusBadRequest)
+		return
+	}
+	report, err := c.achievementService.ReportProgress(r.Context(), &services.ReportProgressRequest{
+		UserID:    gameLogin.UserID,
+		GameID:    gameLogin.GameID,
+		Key:       name,
+		Value:     amount,
+		Increment: increment != "",
+	})
+	if errors.Is(err, services.ErrAchievementDefinitionNotFound) {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Invalid achievement name"}, http.StatusBadRequest)
+		return
+	} else if errors.Is(err, services.ErrAchievementNotProgress) {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Achievement does not track progress"}, http.StatusBadRequest)
+		return
+	} else if errors.Is(err, services.ErrInvalidProgressValue) {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Value must not be negative and increment must be positive"}, http.StatusBadRequest)
+		return
+	} else if errors.Is(err, services.ErrAchievementDefinitionRetired) {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Achievement is retired"}, http.StatusGone)
+		return
+	} else if err != nil {
+		c.jsonResponse(w, achievementErrorResponse{Message: "Failed to report progress"}, http.StatusInternalServerError)
+		return
+	}
+	response := achievementProgressResponse{
+		Name:        report.Definition.Key,
+		DisplayName: report.Definition.Name,
+		Progress:    report.Value,
+		Target:      report.Definition.Target,
+		Unlocked:    report.Unlocked,
+	}
+	if report.Achievement != nil {
+		response.Achievement = &achievementResponse{
+			ID:          report.Achievement.ID,
+			Name:        report.Definition.Key,
+			DisplayName: report.Definition.Name,
+			Points:      report.Definition.Points,
+			UserID:      report.Achievement.UserID,
+		}
+	}
+	c.jsonResponse(w, response, http.StatusOK)
+}
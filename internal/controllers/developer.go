@@ -0,0 +1,381 @@
+package controllers
+
+import (
+	"errors"
+	"gt/internal/middleware"
+	"gt/internal/repository"
+	"gt/internal/services"
+	"gt/internal/templates"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxUploadBytes bounds the multipart forms that may carry an icon, leaving
+// room for the other fields.
+const maxUploadBytes = services.MaxIconBytes + 1<<16
+
+type DeveloperController struct {
+	gameService                  *services.GameService
+	achievementDefinitionService *services.AchievementDefinitionService
+	iconService                  *services.IconService
+}
+
+func NewDeveloperController(gameService *services.GameService, achievementDefinitionService *services.AchievementDefinitionService, iconService *services.IconService) *DeveloperController {
+	return &DeveloperController{gameService: gameService, achievementDefinitionService: achievementDefinitionService, iconService: iconService}
+}
+
+var developerUserErrors = []error{
+	services.ErrGameAlreadyExists,
+	services.ErrInvalidGameSlug,
+	services.ErrInvalidGameName,
+	services.ErrInvalidWebhookURL,
+	services.ErrAchievementDefinitionExists,
+	services.ErrInvalidAchievementKey,
+	services.ErrInvalidAchievementName,
+	services.ErrInvalidAchievementPoints,
+	services.ErrInvalidAchievementTarget,
+	services.ErrIconTooLarge,
+	services.ErrIconNotAnImage,
+}
+
+func developerErrorMessage(err error) (string, bool) {
+	for _, userErr := range developerUserErrors {
+		if errors.Is(err, userErr) {
+			return userErr.Error(), true
+		}
+	}
+	return "", false
+}
+
+func (c *DeveloperController) renderIndex(w http.ResponseWriter, r *http.Request, errorMessage string) {
+	user := middleware.UserFromContext(r.Context())
+	games, err := c.gameService.GetGamesByOwnerID(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = templates.Render(w, r, templates.DeveloperTemplate, templates.DeveloperData{
+		AuthenticatedData: templates.AuthenticatedData{User: user},
+		Games:             games,
+		Error:             errorMessage,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *DeveloperController) renderGame(w http.ResponseWriter, r *http.Request, data templates.DeveloperGameData) {
+	var err error
+	data.User = middleware.UserFromContext(r.Context())
+	data.Definitions, err = c.achievementDefinitionService.GetDefinitionsByGameID(r.Context(), data.Game.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.LinkedPlayers, err = c.gameService.GetLinkedPlayerCount(r.Context(), data.Game.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = templates.Render(w, r, templates.DeveloperGameTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ownedGame loads the game from the {id} path value and writes a 404 when the
+// current user does not own it.
+func (c *DeveloperController) ownedGame(w http.ResponseWriter, r *http.Request) *repository.Game {
+	user := middleware.UserFromContext(r.Context())
+	game, err := c.gameService.GetOwnedGame(r.Context(), r.PathValue("id"), user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrGameNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil
+	}
+	return game
+}
+
+// parseUploadForm parses a form that may carry an icon. The body is limited to
+// maxUploadBytes, and a larger one is reported as ErrIconTooLarge.
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	err := r.ParseMultipartForm(maxUploadBytes)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, http.ErrNotMultipart):
+		return nil
+	case errors.As(err, &maxBytesErr):
+		return services.ErrIconTooLarge
+	}
+	return err
+}
+
+// uploadIcon stores the image sent in the icon field of the parsed multipart
+// form and returns its public URL. It returns an empty URL when no file was
+// sent.
+func (c *DeveloperController) uploadIcon(r *http.Request) (string, error) {
+	file, header, err := r.FormFile("icon")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+	if header.Size > services.MaxIconBytes {
+		return "", services.ErrIconTooLarge
+	}
+	return c.iconService.Upload(r.Context(), file)
+}
+
+// parseTarget parses the optional target of a progress achievement. An empty
+// field means the achievement has no progress.
+func parseTarget(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (c *DeveloperController) redirectToGame(w http.ResponseWriter, r *http.Request, game *repository.Game) {
+	http.Redirect(w, r, "/developer/games/"+game.ID, http.StatusSeeOther)
+}
+
+func (c *DeveloperController) GetIndex(w http.ResponseWriter, r *http.Request) {
+	c.renderIndex(w, r, "")
+}
+
+func (c *DeveloperController) PostGame(w http.ResponseWriter, r *http.Request) {
+	if err := parseUploadForm(w, r); err != nil {
+		if errors.Is(err, services.ErrIconTooLarge) {
+			c.renderIndex(w, r, err.Error())
+		} else {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
+		return
+	}
+	iconURL, err := c.uploadIcon(r)
+	if err != nil {
+		if message, ok := developerErrorMessage(err); ok {
+			c.renderIndex(w, r, message)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	user := middleware.UserFromContext(r.Context())
+	created, err := c.gameService.CreateGame(r.Context(), &services.CreateGameRequest{
+		OwnerID: user.ID,
+		Slug:    strings.TrimSpace(r.FormValue("slug")),
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		IconURL: iconURL,
+	})
+	if err != nil {
+		if message, ok := developerErrorMessage(err); ok {
+			c.renderIndex(w, r, message)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	c.renderGame(w, r, templates.DeveloperGameData{Game: created.Game, ClientSecret: created.ClientSecret})
+}
+
+func (c *DeveloperController) GetGame(w http.ResponseWriter, r *http.Request) {
+	game := c.ownedGame(w, r)
+	if game == nil {
+		return
+	}
+	c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: ""})
+}
+
+func (c *DeveloperController) PostGameSettings(w http.ResponseWriter, r *http.Request) {
+	game := c.ownedGame(w, r)
+	if game == nil {
+		return
+	}
+	if err := parseUploadForm(w, r); err != nil {
+		if errors.Is(err, services.ErrIconTooLarge) {
+			c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: err.Error()})
+		} else {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
+		return
+	}
+	iconURL, err := c.uploadIcon(r)
+	if err == nil {
+		if iconURL != "" {
+			game.IconURL = iconURL
+		}
+		game.Name = strings.TrimSpace(r.FormValue("name"))
+		game.WebhookURL = strings.TrimSpace(r.FormValue("webhook_url"))
+		err = c.gameService.UpdateGame(r.Context(), game)
+	}
+	if err != nil {
+		if message, ok := developerErrorMessage(err); ok {
+			c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: message})
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	c.redirectToGame(w, r, game)
+}
+
+func (c *DeveloperController) PostWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	game := c.ownedGame(w, r)
+	if game == nil {
+		return
+	}
+	if err := c.gameService.RotateWebhookSecret(r.Context(), game); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.redirectToGame(w, r, game)
+}
+
+func (c *DeveloperController) PostClientSecret(w http.ResponseWriter, r *http.Request) {
+	game := c.ownedGame(w, r)
+	if game == nil {
+		return
+	}
+	rotated, err := c.gameService.RotateClientSecret(r.Context(), game)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.renderGame(w, r, templates.DeveloperGameData{Game: rotated.Game, ClientSecret: rotated.ClientSecret})
+}
+
+func (c *DeveloperController) PostAchievement(w http.ResponseWriter, r *http.Request) {
+	game := c.ownedGame(w, r)
+	if game == nil {
+		return
+	}
+	if err := parseUploadForm(w, r); err != nil {
+		if errors.Is(err, services.ErrIconTooLarge) {
+			c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: err.Error()})
+		} else {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
+		return
+	}
+	points, err := strconv.Atoi(r.FormValue("points"))
+	if err != nil {
+		c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: "Points must be a number"})
+		return
+	}
+	target, err := parseTarget(r.FormValue("target"))
+	if err != nil {
+		c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: "Target must be a number"})
+		return
+	}
+	iconURL, err := c.uploadIcon(r)
+	if err == nil {
+		_, err = c.achievementDefinitionService.CreateDefinition(r.Context(), &repository.CreateAchievementDefinitionRequest{
+			GameID:      game.ID,
+			Key:         strings.TrimSpace(r.FormValue("key")),
+			Name:        strings.TrimSpace(r.FormValue("name")),
+			Description: strings.TrimSpace(r.FormValue("description")),
+			IconURL:     iconURL,
+			Points:      points,
+			Target:      target,
+			Hidden:      r.FormValue("hidden") == "on",
+		})
+	}
+	if err != nil {
+		if message, ok := developerErrorMessage(err); ok {
+			c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: message})
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	c.redirectToGame(w, r, game)
+}
+
+func (c *DeveloperController) PostAchievementSettings(w http.ResponseWriter, r *http.Request) {
+	game := c.ownedGame(w, r)
+	if game == nil {
+		return
+	}
+	definition, err := c.achievementDefinitionService.GetDefinitionByID(r.Context(), game.ID, r.PathValue("achievementID"))
+	if err != nil {
+		if errors.Is(err, services.ErrAchievementDefinitionNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := parseUploadForm(w, r); err != nil {
+		if errors.Is(err, services.ErrIconTooLarge) {
+			c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: err.Error()})
+		} else {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
+		return
+	}
+	points, err := strconv.Atoi(r.FormValue("points"))
+	if err != nil {
+		c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: "Points must be a number"})
+		return
+	}
+	target, err := parseTarget(r.FormValue("target"))
+	if err != nil {
+		c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: "Target must be a number"})
+		return
+	}
+	iconURL, err := c.uploadIcon(r)
+	if err == nil {
+		if iconURL != "" {
+			definition.IconURL = iconURL
+		}
+		definition.Name = strings.TrimSpace(r.FormValue("name"))
+		definition.Description = strings.TrimSpace(r.FormValue("description"))
+		definition.Points = points
+		definition.Target = target
+		definition.Hidden = r.FormValue("hidden") == "on"
+		err = c.achievementDefinitionService.UpdateDefinition(r.Context(), definition)
+	}
+	if err != nil {
+		if message, ok := developerErrorMessage(err); ok {
+			c.renderGame(w, r, templates.DeveloperGameData{Game: game, Error: message})
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	c.redirectToGame(w, r, game)
+}
+
+func (c *DeveloperController) PostAchievementRetire(w http.ResponseWriter, r *http.Request) {
+	game := c.ownedGame(w, r)
+	if game == nil {
+		return
+	}
+	definition, err := c.achievementDefinitionService.GetDefinitionByID(r.Context(), game.ID, r.PathValue("achievementID"))
+	if err != nil {
+		if errors.Is(err, services.ErrAchievementDefinitionNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	retired := r.FormValue("retired") == "true"
+	if err := c.achievementDefinitionService.SetDefinitionRetired(r.Context(), definition, retired); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.redirectToGame(w, r, game)
+}
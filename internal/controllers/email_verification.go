@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"errors"
+	"gt/internal/middleware"
+	"gt/internal/services"
+	"gt/internal/templates"
+	"net/http"
+)
+
+type EmailVerificationController struct {
+	verificationService *services.EmailVerificationService
+}
+
+func NewEmailVerificationController(verificationService *services.EmailVerificationService) *EmailVerificationController {
+	return &EmailVerificationController{verificationService: verificationService}
+}
+
+func (c *EmailVerificationController) renderTemplate(w http.ResponseWriter, r *http.Request, data *templates.VerifyEmailData) {
+	err := templates.Render(w, r, templates.VerifyEmailTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *EmailVerificationController) GetVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	_, err := c.verificationService.Verify(r.Context(), r.URL.Query().Get("token"))
+	if errors.Is(err, services.ErrInvalidVerificationToken) {
+		c.renderTemplate(w, r, &templates.VerifyEmailData{Error: "This verification link is invalid or has expired."})
+		return
+	}
+	if errors.Is(err, services.ErrEmailTaken) {
+		c.renderTemplate(w, r, &templates.VerifyEmailData{Error: "This email address is already used by another account."})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	c.renderTemplate(w, r, &templates.VerifyEmailData{Verified: true})
+}
+
+func (c *EmailVerificationController) PostResend(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	err := c.verificationService.SendVerification(r.Context(), user)
+	switch {
+	case errors.Is(err, services.ErrEmailAlreadyVerified):
+		c.renderTemplate(w, r, &templates.VerifyEmailData{Verified: true})
+	case errors.Is(err, services.ErrTooManyAttempts):
+		c.renderTemplate(w, r, &templates.VerifyEmailData{Error: "Too many verification emails sent. Please try again later."})
+	case err != nil:
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+	default:
+		c.renderTemplate(w, r, &templates.VerifyEmailData{Sent: true, Email: user.Email})
+	}
+}
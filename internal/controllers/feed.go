@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"gt/internal/middleware"
-	"gt/internal/repository"
 	"gt/internal/services"
 	"gt/internal/templates"
 	"net/http"
@@ -28,14 +27,40 @@ func (c *FeedController) GetFeed(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	gameIndex := map[string]int{}
 	for _, achievement := range achievements {
-		data.Achievements = append(data.Achievements, templates.AchievementData{
-			Name:      repository.AchievementName(achievement.Name).String(),
-			ImageURL:  repository.AchievementName(achievement.Name).ImageURL(),
-			CreatedAt: achievement.CreatedAt,
+		game := achievement.Definition.Game
+		i, ok := gameIndex[game.ID]
+		if !ok {
+			i = len(data.Games)
+			gameIndex[game.ID] = i
+			data.Games = append(data.Games, templates.GameAchievementsData{Name: game.Name})
+		}
+		data.Games[i].Achievements = append(data.Games[i].Achievements, templates.AchievementData{
+			Name:        achievement.Definition.Name,
+			Description: achievement.Definition.Description,
+			ImageURL:    achievement.Definition.IconURL,
+			Points:      achievement.Definition.Points,
+			CreatedAt:   achievement.CreatedAt,
 		})
 	}
-	err = templates.FeedTemplate.Execute(w, data)
+	progress, err := c.achievementService.GetProgressByUserID(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, p := range progress {
+		data.InProgress = append(data.InProgress, templates.AchievementProgressData{
+			GameName:    p.Definition.Game.Name,
+			Name:        p.Definition.Name,
+			Description: p.Definition.Description,
+			ImageURL:    p.Definition.IconURL,
+			Points:      p.Definition.Points,
+			Progress:    min(p.Value, p.Definition.Target),
+			Target:      p.Definition.Target,
+		})
+	}
+	err = templates.Render(w, r, templates.FeedTemplate, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -3,20 +3,25 @@ package controllers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"gt/internal/middleware"
+	"gt/internal/repository"
 	"gt/internal/services"
 	"gt/internal/templates"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 type GameController struct {
-	gameService *services.GameService
-	userService *services.UserService
+	gameService   *services.GameService
+	userService   *services.UserService
+	avatarService *services.AvatarService
+	baseURL       string
 }
 
-func NewGameController(gameService *services.GameService, userService *services.UserService) *GameController {
-	return &GameController{gameService: gameService, userService: userService}
+func NewGameController(gameService *services.GameService, userService *services.UserService, avatarService *services.AvatarService, baseURL string) *GameController {
+	return &GameController{gameService: gameService, userService: userService, avatarService: avatarService, baseURL: baseURL}
 }
 
 func (c *GameController) jsonResponse(w http.ResponseWriter, data any, statusCode int) {
@@ -25,24 +30,33 @@ func (c *GameController) jsonResponse(w http.ResponseWriter, data any, statusCod
 	json.NewEncoder(w).Encode(data)
 }
 
-func (c *GameController) renderTemplate(w http.ResponseWriter, data *templates.GameData) {
-	err := templates.GameLoginTemplate.Execute(w, data)
+func (c *GameController) renderTemplate(w http.ResponseWriter, r *http.Request, data *templates.GameData) {
+	err := templates.Render(w, r, templates.GameLoginTemplate, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func (c *GameController) renderGameOKTemplate(w http.ResponseWriter, data *templates.GameData) {
-	err := templates.GameOKTemplate.Execute(w, data)
+func (c *GameController) renderGameOKTemplate(w http.ResponseWriter, r *http.Request, data *templates.GameData) {
+	err := templates.Render(w, r, templates.GameOKTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *GameController) renderActivateTemplate(w http.ResponseWriter, r *http.Request, data *templates.GameActivateData) {
+	err := templates.Render(w, r, templates.GameActivateTemplate, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 type gameLoginRequestResponse struct {
-	ID    string `json:"id"`
-	URL   string `json:"url,omitempty"`
-	Token string `json:"token,omitempty"`
+	ID          string `json:"id"`
+	URL         string `json:"url,omitempty"`
+	Token       string `json:"token,omitempty"`
+	UserCode    string `json:"user_code,omitempty"`
+	ActivateURL string `json:"activate_url,omitempty"`
 }
 
 type gameErrorResponse struct {
@@ -52,40 +66,99 @@ type gameErrorResponse struct {
 type gameLoginState struct {
 	ID     string  `json:"id"`
 	UserID *string `json:"user_id"`
+	State  string  `json:"state"`
+}
+
+const (
+	gameLoginStatePending      = "pending"
+	gameLoginStateApproved     = "approved"
+	gameLoginStateAccessDenied = "access_denied"
+	gameLoginStateCancelled    = "cancelled"
+	gameLoginStateExpired      = "expired"
+)
+
+const gameLoginEventsHeartbeat = 15 * time.Second
+
+func newGameLoginState(req *repository.GameLoginRequest) gameLoginState {
+	state := gameLoginState{ID: req.ID, UserID: req.UserID, State: gameLoginStatePending}
+	switch req.Status {
+	case repository.GameLoginRequestApproved:
+		state.State = gameLoginStateApproved
+	case repository.GameLoginRequestDenied:
+		state.State = gameLoginStateAccessDenied
+	case repository.GameLoginRequestCancelled:
+		state.State = gameLoginStateCancelled
+	}
+	return state
 }
 
 type gameUser struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	// AvatarURL is null when the user has no avatar.
+	AvatarURL *string `json:"avatar_url"`
 }
 
 type gameLogin struct {
-	ID    string `json:"id"`
-	Token string `json:"token"`
+	ID           string `json:"id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func newGameLogin(exchanged *services.GameLoginExchanged) gameLogin {
+	return gameLogin{
+		ID:           exchanged.GameLogin.ID,
+		Token:        exchanged.Token,
+		RefreshToken: exchanged.RefreshToken,
+		ExpiresIn:    exchanged.ExpiresIn(),
+	}
+}
+
+// gameClientCredentials reads the client ID and secret from HTTP Basic auth,
+// falling back to the client_id and client_secret form values.
+func gameClientCredentials(r *http.Request) (string, string) {
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		return clientID, clientSecret
+	}
+	return r.FormValue("client_id"), r.FormValue("client_secret")
 }
 
 func (c *GameController) CreateGameLoginRequest(w http.ResponseWriter, r *http.Request) {
-	req, err := c.gameService.CreateGameLoginRequest(r.Context())
+	clientID, clientSecret := gameClientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		c.jsonResponse(w, gameErrorResponse{Message: "Missing client credentials"}, http.StatusUnauthorized)
+		return
+	}
+	game, err := c.gameService.AuthenticateGame(r.Context(), clientID, clientSecret)
 	if err != nil {
-		c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
+		if errors.Is(err, services.ErrInvalidGameCredentials) {
+			c.jsonResponse(w, gameErrorResponse{Message: "Invalid client credentials"}, http.StatusUnauthorized)
+		} else {
+			c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
+		}
 		return
 	}
-	u := url.URL{
-		Scheme: "http",
-		Host:   r.Host,
-		Path:   "/game",
+	req, err := c.gameService.CreateGameLoginRequest(r.Context(), game, deviceInfo(r))
+	if err != nil {
+		c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
+		return
 	}
-	query := u.Query()
-	query.Set("id", req.GameLoginRequest.ID)
-	u.RawQuery = query.Encode()
-	c.jsonResponse(w, gameLoginRequestResponse{ID: req.GameLoginRequest.ID, URL: u.String(), Token: req.Token}, http.StatusCreated)
+	c.jsonResponse(w, gameLoginRequestResponse{
+		ID:          req.GameLoginRequest.ID,
+		URL:         c.baseURL + "/game?" + url.Values{"id": []string{req.GameLoginRequest.ID}}.Encode(),
+		Token:       req.Token,
+		UserCode:    req.GameLoginRequest.UserCode,
+		ActivateURL: c.baseURL + "/game/activate",
+	}, http.StatusCreated)
 }
 
 func (c *GameController) GetGameLoginPage(w http.ResponseWriter, r *http.Request) {
 	requestID := r.URL.Query().Get("id")
 	if requestID == "" {
-		c.renderTemplate(w, &templates.GameData{Error: "Missing request ID"})
+		c.renderTemplate(w, r, &templates.GameData{Error: "Missing request ID"})
 		return
 	}
 	user := middleware.UserFromContext(r.Context())
@@ -100,10 +173,53 @@ func (c *GameController) GetGameLoginPage(w http.ResponseWriter, r *http.Request
 	}
 	gameLoginRequest, err := c.gameService.GetGameLoginRequest(r.Context(), requestID)
 	if err != nil {
-		c.renderTemplate(w, &templates.GameData{Error: err.Error()})
+		c.renderTemplate(w, r, &templates.GameData{Error: err.Error()})
+		return
+	}
+	c.renderTemplate(w, r, &templates.GameData{GameLoginRequest: gameLoginRequest, User: user})
+}
+
+func (c *GameController) GetActivatePage(w http.ResponseWriter, r *http.Request) {
+	userCode := r.URL.Query().Get("user_code")
+	if middleware.UserFromContext(r.Context()) == nil {
+		query := url.Values{}
+		query.Set("redirect", LoginRedirectData{
+			Action:   LoginActionGameActivate,
+			UserCode: userCode,
+		}.ToQuery())
+		http.Redirect(w, r, "/login?"+query.Encode(), http.StatusSeeOther)
+		return
+	}
+	c.renderActivateTemplate(w, r, &templates.GameActivateData{UserCode: userCode})
+}
+
+func (c *GameController) PostActivate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	userCode := r.FormValue("user_code")
+	if userCode == "" {
+		c.renderActivateTemplate(w, r, &templates.GameActivateData{Error: "Code is required"})
+		return
+	}
+	req, err := c.gameService.Activate(r.Context(), services.ActivateRequest{
+		UserCode: userCode,
+		UserID:   middleware.UserFromContext(r.Context()).ID,
+		IP:       middleware.ClientIP(r),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrGameLoginRequestNotFound) {
+			c.renderActivateTemplate(w, r, &templates.GameActivateData{UserCode: userCode, Error: "Invalid or expired code"})
+		} else if errors.Is(err, services.ErrTooManyAttempts) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			c.renderActivateTemplate(w, r, &templates.GameActivateData{UserCode: userCode, Error: "Too many invalid codes. Please try again later."})
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
-	c.renderTemplate(w, &templates.GameData{GameLoginRequest: gameLoginRequest, User: user})
+	http.Redirect(w, r, "/game?"+url.Values{"id": []string{req.ID}}.Encode(), http.StatusSeeOther)
 }
 
 func (c *GameController) PostGameLogin(w http.ResponseWriter, r *http.Request) {
@@ -117,12 +233,35 @@ func (c *GameController) PostGameLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := middleware.UserFromContext(r.Context())
+	if r.FormValue("action") == "deny" {
+		if err := c.gameService.Deny(r.Context(), requestID); err != nil {
+			c.renderConsentError(w, r, user, err)
+			return
+		}
+		c.renderGameOKTemplate(w, r, &templates.GameData{User: user, Denied: true})
+		return
+	}
 	err := c.gameService.Login(r.Context(), requestID, user)
 	if err != nil {
+		c.renderConsentError(w, r, user, err)
+		return
+	}
+	c.renderGameOKTemplate(w, r, &templates.GameData{User: user})
+}
+
+// renderConsentError shows why approving or denying a login request failed.
+// A request that expired or was answered in the meantime, e.g. from another
+// tab, is not a server error.
+func (c *GameController) renderConsentError(w http.ResponseWriter, r *http.Request, user *repository.User, err error) {
+	if errors.Is(err, services.ErrGameLoginRequestNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, services.ErrGameLoginRequestUsed) {
+		w.WriteHeader(http.StatusConflict)
+	} else {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	c.renderGameOKTemplate(w, &templates.GameData{User: user})
+	c.renderTemplate(w, r, &templates.GameData{User: user, Error: "This login request has expired or was already used"})
 }
 
 func (c *GameController) GetGameLoginState(w http.ResponseWriter, r *http.Request) {
@@ -141,8 +280,85 @@ func (c *GameController) GetGameLoginState(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	response := gameLoginState{ID: req.ID, UserID: req.UserID}
-	c.jsonResponse(w, response, http.StatusOK)
+	c.jsonResponse(w, newGameLoginState(req), http.StatusOK)
+}
+
+// GetGameLoginEvents is a Server-Sent Events variant of GetGameLoginState. It
+// pushes a "state" event on every change and closes the stream once the
+// request is no longer pending.
+func (c *GameController) GetGameLoginEvents(w http.ResponseWriter, r *http.Request) {
+	requestID := r.URL.Query().Get("id")
+	token := r.URL.Query().Get("token")
+	if requestID == "" || token == "" {
+		c.jsonResponse(w, gameErrorResponse{Message: "Missing request ID or token"}, http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		c.jsonResponse(w, gameErrorResponse{Message: "Streaming unsupported"}, http.StatusInternalServerError)
+		return
+	}
+	states, err := c.gameService.WatchGameLoginRequestState(r.Context(), requestID, token)
+	if err != nil {
+		if errors.Is(err, services.ErrGameLoginRequestNotFound) {
+			c.jsonResponse(w, gameErrorResponse{Message: "Game login request not found"}, http.StatusNotFound)
+		} else {
+			c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(gameLoginEventsHeartbeat)
+	defer heartbeat.Stop()
+	last := gameLoginState{ID: requestID, State: gameLoginStatePending}
+	for {
+		select {
+		case req, ok := <-states:
+			if !ok {
+				if last.State == gameLoginStatePending && r.Context().Err() == nil {
+					last.State = gameLoginStateExpired
+					writeGameLoginEvent(w, last)
+					flusher.Flush()
+				}
+				return
+			}
+			last = newGameLoginState(req)
+			writeGameLoginEvent(w, last)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeGameLoginEvent(w http.ResponseWriter, state gameLoginState) {
+	data, _ := json.Marshal(state)
+	fmt.Fprintf(w, "event: state\ndata: %s\n\n", data)
+}
+
+func (c *GameController) CancelGameLoginRequest(w http.ResponseWriter, r *http.Request) {
+	requestID := r.FormValue("id")
+	token := r.FormValue("token")
+	if requestID == "" || token == "" {
+		c.jsonResponse(w, gameErrorResponse{Message: "Missing request ID or token"}, http.StatusBadRequest)
+		return
+	}
+	err := c.gameService.Cancel(r.Context(), requestID, token)
+	if err != nil {
+		if errors.Is(err, services.ErrGameLoginRequestNotFound) {
+			c.jsonResponse(w, gameErrorResponse{Message: "Game login request not found"}, http.StatusNotFound)
+		} else {
+			c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (c *GameController) ExchangeGameLoginCode(w http.ResponseWriter, r *http.Request) {
@@ -154,20 +370,46 @@ func (c *GameController) ExchangeGameLoginCode(w http.ResponseWriter, r *http.Re
 	}
 	code, err := c.gameService.Exchange(r.Context(), id, token)
 	if err != nil {
-		if errors.Is(err, services.ErrGameLoginRequestNotFound) {
+		if errors.Is(err, services.ErrGameLoginRequestNotFound) || errors.Is(err, services.ErrGameLoginRequestUsed) {
 			c.jsonResponse(w, gameErrorResponse{Message: "Game login request not found"}, http.StatusNotFound)
+		} else if errors.Is(err, services.ErrAccessDenied) {
+			c.jsonResponse(w, gameErrorResponse{Message: "User denied the game login request"}, http.StatusForbidden)
 		} else {
 			c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
 		}
 		return
 	}
-	c.jsonResponse(w, gameLogin{
-		ID:    code.GameLogin.ID,
-		Token: code.Token,
-	}, http.StatusOK)
+	c.jsonResponse(w, newGameLogin(code), http.StatusOK)
+}
+
+func (c *GameController) RefreshGameLogin(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.FormValue("refresh_token")
+	if refreshToken == "" {
+		c.jsonResponse(w, gameErrorResponse{Message: "Missing refresh token"}, http.StatusBadRequest)
+		return
+	}
+	refreshed, err := c.gameService.Refresh(r.Context(), refreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusUnauthorized)
+		} else {
+			c.jsonResponse(w, gameErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
+		}
+		return
+	}
+	c.jsonResponse(w, newGameLogin(refreshed), http.StatusOK)
 }
 
 func (c *GameController) GetUser(w http.ResponseWriter, r *http.Request) {
 	user := middleware.GameLoginFromContext(r.Context()).User
-	c.jsonResponse(w, gameUser{ID: user.ID, Username: user.Username, Email: user.Email}, http.StatusOK)
+	response := gameUser{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	}
+	if avatarURL := c.avatarService.URL(user, 256); avatarURL != "" {
+		response.AvatarURL = &avatarURL
+	}
+	c.jsonResponse(w, response, http.StatusOK)
 }
@@ -1,8 +1,13 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"gt/internal/middleware"
+	"gt/internal/repository"
 	"gt/internal/services"
 	"gt/internal/templates"
+	"math"
 	"net/http"
 	"net/url"
 	"time"
@@ -16,20 +21,22 @@ func NewLoginController(authService *services.AuthService) *LoginController {
 	return &LoginController{authService: authService}
 }
 
-func (c *LoginController) renderTemplate(w http.ResponseWriter, data *templates.LoginData) {
-	err := templates.LoginTemplate.Execute(w, data)
+func (c *LoginController) renderTemplate(w http.ResponseWriter, r *http.Request, data *templates.LoginData) {
+	err := templates.Render(w, r, templates.LoginTemplate, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 const (
-	LoginActionGameLogin = "game_login"
+	LoginActionGameLogin    = "game_login"
+	LoginActionGameActivate = "game_activate"
 )
 
 type LoginRedirectData struct {
 	Action             string
 	GameLoginRequestID string
+	UserCode           string
 }
 
 func (l LoginRedirectData) ToQuery() string {
@@ -38,6 +45,9 @@ func (l LoginRedirectData) ToQuery() string {
 	if l.GameLoginRequestID != "" {
 		query.Set("game_login_request_id", l.GameLoginRequestID)
 	}
+	if l.UserCode != "" {
+		query.Set("user_code", l.UserCode)
+	}
 	return query.Encode()
 }
 
@@ -45,6 +55,11 @@ func (l LoginRedirectData) GetRedirectPath() string {
 	switch l.Action {
 	case LoginActionGameLogin:
 		return "/game?" + url.Values{"id": []string{l.GameLoginRequestID}}.Encode()
+	case LoginActionGameActivate:
+		if l.UserCode == "" {
+			return "/game/activate"
+		}
+		return "/game/activate?" + url.Values{"user_code": []string{l.UserCode}}.Encode()
 	default:
 		return "/feed"
 	}
@@ -58,12 +73,13 @@ func ParseLoginRedirectData(redirect string) (LoginRedirectData, error) {
 	return LoginRedirectData{
 		Action:             query.Get("action"),
 		GameLoginRequestID: query.Get("game_login_request_id"),
+		UserCode:           query.Get("user_code"),
 	}, nil
 }
 
 func (c *LoginController) GetLogin(w http.ResponseWriter, r *http.Request) {
 	redirect := r.URL.Query().Get("redirect")
-	c.renderTemplate(w, &templates.LoginData{
+	c.renderTemplate(w, r, &templates.LoginData{
 		Redirect: redirect,
 	})
 }
@@ -77,31 +93,98 @@ func (c *LoginController) PostLogin(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	redirect := r.FormValue("redirect")
 	if username == "" || password == "" {
-		c.renderTemplate(w, &templates.LoginData{
+		c.renderTemplate(w, r, &templates.LoginData{
 			Error:    "Username and password are required",
 			Redirect: redirect,
 		})
 		return
 	}
 	session, err := c.authService.Login(r.Context(), services.LoginRequest{
-		Username:  username,
-		Password:  password,
-		UserAgent: r.UserAgent(),
+		Username:   username,
+		Password:   password,
+		UserAgent:  r.UserAgent(),
+		IP:         middleware.ClientIP(r),
+		RememberMe: r.FormValue("remember_me") == "on",
 	})
-	if err != nil {
-		c.renderTemplate(w, &templates.LoginData{
+	var secondFactor *services.SecondFactorRequiredError
+	if errors.As(err, &secondFactor) {
+		c.renderTemplate(w, r, &templates.LoginData{
+			Challenge: secondFactor.Challenge,
+			Redirect:  redirect,
+		})
+		return
+	}
+	var lockedOut *services.LockedOutError
+	if errors.As(err, &lockedOut) {
+		c.renderTemplate(w, r, &templates.LoginData{
+			Error:    lockedOutMessage(lockedOut),
+			Redirect: redirect,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		c.renderTemplate(w, r, &templates.LoginData{
 			Error:    "Invalid username or password",
 			Redirect: redirect,
 		})
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    session.ID,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  time.Now().Add(24 * time.Hour),
+	if err != nil {
+		http.Error(w, "Failed to login", http.StatusInternalServerError)
+		return
+	}
+	c.completeLogin(w, r, session, redirect)
+}
+
+// PostLoginSecondFactor is the second step of a login for accounts with
+// two-factor authentication. The redirect is carried through from the first
+// step.
+func (c *LoginController) PostLoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	challenge := r.FormValue("challenge")
+	redirect := r.FormValue("redirect")
+	session, err := c.authService.LoginSecondFactor(r.Context(), services.LoginSecondFactorRequest{
+		Challenge: challenge,
+		Code:      r.FormValue("code"),
+		UserAgent: r.UserAgent(),
+		IP:        middleware.ClientIP(r),
 	})
+	if errors.Is(err, services.ErrLoginChallengeExpired) {
+		c.renderTemplate(w, r, &templates.LoginData{
+			Error:    "Your login attempt expired. Please sign in again.",
+			Redirect: redirect,
+		})
+		return
+	}
+	var lockedOut *services.LockedOutError
+	if errors.As(err, &lockedOut) {
+		c.renderTemplate(w, r, &templates.LoginData{
+			Error:    lockedOutMessage(lockedOut),
+			Redirect: redirect,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidSecondFactor) {
+		c.renderTemplate(w, r, &templates.LoginData{
+			Challenge: challenge,
+			Error:     "Invalid authentication or recovery code",
+			Redirect:  redirect,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to login", http.StatusInternalServerError)
+		return
+	}
+	c.completeLogin(w, r, session, redirect)
+}
+
+func (c *LoginController) completeLogin(w http.ResponseWriter, r *http.Request, session *repository.Session, redirect string) {
+	middleware.SetSessionCookie(w, session)
+	middleware.RotateCSRFToken(w)
 	if redirect != "" {
 		redirectData, err := ParseLoginRedirectData(redirect)
 		if err != nil {
@@ -113,3 +196,18 @@ func (c *LoginController) PostLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	http.Redirect(w, r, "/feed", http.StatusSeeOther)
 }
+
+func lockedOutMessage(err *services.LockedOutError) string {
+	return "Too many failed login attempts. Try again in " + formatRetryAfter(err.RetryAfter) + "."
+}
+
+func formatRetryAfter(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	minutes := int(math.Ceil(d.Minutes()))
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"gt/internal/repository"
+	"gt/internal/services"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// OAuthController exposes the game login flow as an RFC 8628 device
+// authorization grant so standard OAuth client libraries can use it.
+type OAuthController struct {
+	gameService *services.GameService
+	baseURL     string
+}
+
+func NewOAuthController(gameService *services.GameService, baseURL string) *OAuthController {
+	return &OAuthController{gameService: gameService, baseURL: baseURL}
+}
+
+const (
+	deviceCodeGrantType   = "urn:ietf:params:oauth:grant-type:device_code"
+	refreshTokenGrantType = "refresh_token"
+)
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func newTokenResponse(exchanged *services.GameLoginExchanged) tokenResponse {
+	return tokenResponse{
+		AccessToken:  exchanged.AccessToken(),
+		TokenType:    "Bearer",
+		ExpiresIn:    exchanged.ExpiresIn(),
+		RefreshToken: exchanged.RefreshToken,
+	}
+}
+
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func (c *OAuthController) jsonResponse(w http.ResponseWriter, data any, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+// authenticateClient writes an invalid_client error and returns nil when the
+// request does not carry valid game credentials.
+func (c *OAuthController) authenticateClient(w http.ResponseWriter, r *http.Request) *repository.Game {
+	clientID, clientSecret := gameClientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		c.jsonResponse(w, oauthErrorResponse{Error: "invalid_client", ErrorDescription: "Missing client credentials"}, http.StatusUnauthorized)
+		return nil
+	}
+	game, err := c.gameService.AuthenticateGame(r.Context(), clientID, clientSecret)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidGameCredentials) {
+			c.jsonResponse(w, oauthErrorResponse{Error: "invalid_client", ErrorDescription: "Invalid client credentials"}, http.StatusUnauthorized)
+		} else {
+			c.jsonResponse(w, oauthErrorResponse{Error: "server_error"}, http.StatusInternalServerError)
+		}
+		return nil
+	}
+	return game
+}
+
+func (c *OAuthController) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	game := c.authenticateClient(w, r)
+	if game == nil {
+		return
+	}
+	req, err := c.gameService.CreateGameLoginRequest(r.Context(), game, deviceInfo(r))
+	if err != nil {
+		c.jsonResponse(w, oauthErrorResponse{Error: "server_error"}, http.StatusInternalServerError)
+		return
+	}
+	verificationURI := c.baseURL + "/game/activate"
+	c.jsonResponse(w, deviceAuthorizationResponse{
+		DeviceCode:              req.DeviceCode(),
+		UserCode:                req.GameLoginRequest.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": []string{req.GameLoginRequest.UserCode}}.Encode(),
+		ExpiresIn:               int(time.Until(req.GameLoginRequest.ExpiresAt).Seconds()),
+		Interval:                req.GameLoginRequest.Interval,
+	}, http.StatusOK)
+}
+
+func (c *OAuthController) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		c.jsonResponse(w, oauthErrorResponse{Error: "invalid_request"}, http.StatusBadRequest)
+		return
+	}
+	switch r.PostFormValue("grant_type") {
+	case deviceCodeGrantType:
+		c.deviceCodeToken(w, r)
+	case refreshTokenGrantType:
+		c.refreshToken(w, r)
+	default:
+		c.jsonResponse(w, oauthErrorResponse{Error: "unsupported_grant_type"}, http.StatusBadRequest)
+	}
+}
+
+func (c *OAuthController) deviceCodeToken(w http.ResponseWriter, r *http.Request) {
+	deviceCode := r.PostFormValue("device_code")
+	if deviceCode == "" {
+		c.jsonResponse(w, oauthErrorResponse{Error: "invalid_request", ErrorDescription: "Missing device_code"}, http.StatusBadRequest)
+		return
+	}
+	game := c.authenticateClient(w, r)
+	if game == nil {
+		return
+	}
+	exchanged, err := c.gameService.ExchangeDeviceCode(r.Context(), game, deviceCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAuthorizationPending):
+			c.jsonResponse(w, oauthErrorResponse{Error: "authorization_pending"}, http.StatusBadRequest)
+		case errors.Is(err, services.ErrSlowDown):
+			c.jsonResponse(w, oauthErrorResponse{Error: "slow_down"}, http.StatusBadRequest)
+		case errors.Is(err, services.ErrAccessDenied):
+			c.jsonResponse(w, oauthErrorResponse{Error: "access_denied"}, http.StatusBadRequest)
+		case errors.Is(err, services.ErrDeviceCodeExpired):
+			c.jsonResponse(w, oauthErrorResponse{Error: "expired_token"}, http.StatusBadRequest)
+		case errors.Is(err, services.ErrInvalidDeviceCode), errors.Is(err, services.ErrGameLoginRequestUsed):
+			c.jsonResponse(w, oauthErrorResponse{Error: "invalid_grant"}, http.StatusBadRequest)
+		default:
+			c.jsonResponse(w, oauthErrorResponse{Error: "server_error"}, http.StatusInternalServerError)
+		}
+		return
+	}
+	c.jsonResponse(w, newTokenResponse(exchanged), http.StatusOK)
+}
+
+func (c *OAuthController) refreshToken(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		c.jsonResponse(w, oauthErrorResponse{Error: "invalid_request", ErrorDescription: "Missing refresh_token"}, http.StatusBadRequest)
+		return
+	}
+	game := c.authenticateClient(w, r)
+	if game == nil {
+		return
+	}
+	refreshed, err := c.gameService.RefreshForGame(r.Context(), game, refreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.jsonResponse(w, oauthErrorResponse{Error: "invalid_grant", ErrorDescription: err.Error()}, http.StatusBadRequest)
+		} else {
+			c.jsonResponse(w, oauthErrorResponse{Error: "server_error"}, http.StatusInternalServerError)
+		}
+		return
+	}
+	c.jsonResponse(w, newTokenResponse(refreshed), http.StatusOK)
+}
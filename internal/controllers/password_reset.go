@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"gt/internal/middleware"
+	"gt/internal/services"
+	"gt/internal/templates"
+	"net/http"
+)
+
+type PasswordResetController struct {
+	resetService *services.PasswordResetService
+}
+
+func NewPasswordResetController(resetService *services.PasswordResetService) *PasswordResetController {
+	return &PasswordResetController{resetService: resetService}
+}
+
+func (c *PasswordResetController) renderForgot(w http.ResponseWriter, r *http.Request, data *templates.ForgotPasswordData) {
+	err := templates.Render(w, r, templates.ForgotPasswordTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *PasswordResetController) renderReset(w http.ResponseWriter, r *http.Request, data *templates.ResetPasswordData) {
+	// The token is in the URL; keep it out of the Referer sent to other sites.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err := templates.Render(w, r, templates.ResetPasswordTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *PasswordResetController) GetForgot(w http.ResponseWriter, r *http.Request) {
+	c.renderForgot(w, r, &templates.ForgotPasswordData{})
+}
+
+func (c *PasswordResetController) PostForgot(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+	if email == "" {
+		c.renderForgot(w, r, &templates.ForgotPasswordData{Error: "Email is required"})
+		return
+	}
+	err := c.resetService.RequestReset(r.Context(), services.RequestResetRequest{
+		Email: email,
+		IP:    middleware.ClientIP(r),
+	})
+	if errors.Is(err, services.ErrTooManyAttempts) {
+		c.renderForgot(w, r, &templates.ForgotPasswordData{Error: "Too many reset requests. Please try again later."})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to request password reset", http.StatusInternalServerError)
+		return
+	}
+	c.renderForgot(w, r, &templates.ForgotPasswordData{Sent: true})
+}
+
+func (c *PasswordResetController) GetReset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, err := c.resetService.CheckResetToken(r.Context(), token)
+	if errors.Is(err, services.ErrInvalidResetToken) {
+		c.renderReset(w, r, &templates.ResetPasswordData{Error: "This password reset link is invalid or has expired."})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to check password reset link", http.StatusInternalServerError)
+		return
+	}
+	c.renderReset(w, r, &templates.ResetPasswordData{Token: token})
+}
+
+func (c *PasswordResetController) PostReset(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	if password != r.FormValue("password_confirm") {
+		c.renderReset(w, r, &templates.ResetPasswordData{Token: token, Error: "Passwords don't match"})
+		return
+	}
+	err := c.resetService.ResetPassword(r.Context(), token, password)
+	var policyErr *services.PasswordPolicyError
+	switch {
+	case errors.Is(err, services.ErrInvalidResetToken):
+		c.renderReset(w, r, &templates.ResetPasswordData{Error: "This password reset link is invalid or has expired."})
+	case errors.As(err, &policyErr):
+		c.renderReset(w, r, &templates.ResetPasswordData{Token: token, Error: policyErr.Message})
+	case err != nil:
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+	default:
+		middleware.ClearSessionCookie(w)
+		middleware.RotateCSRFToken(w)
+		c.renderReset(w, r, &templates.ResetPasswordData{Done: true})
+	}
+}
@@ -1,17 +1,263 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"gt/internal/middleware"
+	"gt/internal/repository"
 	"gt/internal/services"
+	"gt/internal/templates"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 type ProfileController struct {
-	authService *services.AuthService
+	authService    *services.AuthService
+	userService    *services.UserService
+	profileService *services.ProfileService
+	friendService  *services.FriendService
+	avatarService  *services.AvatarService
 }
 
-func NewProfileController(authService *services.AuthService) *ProfileController {
-	return &ProfileController{authService: authService}
+func NewProfileController(authService *services.AuthService, userService *services.UserService, profileService *services.ProfileService, friendService *services.FriendService, avatarService *services.AvatarService) *ProfileController {
+	return &ProfileController{authService: authService, userService: userService, profileService: profileService, friendService: friendService, avatarService: avatarService}
+}
+
+func profileURL(username string) string {
+	return "/u/" + url.PathEscape(username)
+}
+
+func (c *ProfileController) GetMyProfile(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	http.Redirect(w, r, profileURL(user.Username), http.StatusSeeOther)
+}
+
+func (c *ProfileController) GetProfile(w http.ResponseWriter, r *http.Request) {
+	viewer := middleware.UserFromContext(r.Context())
+	profile, err := c.profileService.GetProfile(r.Context(), viewer, r.PathValue("username"))
+	if errors.Is(err, services.ErrProfileNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Usernames are matched case-insensitively; keep one URL per profile.
+	if profile.User.Username != r.PathValue("username") {
+		http.Redirect(w, r, profileURL(profile.User.Username), http.StatusMovedPermanently)
+		return
+	}
+	data := templates.ProfileData{
+		User:            viewer,
+		Username:        profile.User.Username,
+		AvatarURL:       c.avatarService.URL(profile.User, 128),
+		Own:             viewer != nil && viewer.ID == profile.User.ID,
+		Hidden:          profile.Hidden,
+		TotalPoints:     profile.TotalPoints,
+		IsFriend:        profile.Friendship == services.FriendshipFriends,
+		RequestSent:     profile.Friendship == services.FriendshipRequested,
+		RequestReceived: profile.Friendship == services.FriendshipIncoming,
+	}
+	for _, game := range profile.Games {
+		gameData := templates.ProfileGameData{
+			Name:    game.Game.Name,
+			IconURL: game.Game.IconURL,
+			Points:  game.Points,
+		}
+		for _, achievement := range game.Achievements {
+			gameData.Achievements = append(gameData.Achievements, templates.AchievementData{
+				Name:        achievement.Definition.Name,
+				Description: achievement.Definition.Description,
+				ImageURL:    achievement.Definition.IconURL,
+				Points:      achievement.Definition.Points,
+				CreatedAt:   achievement.CreatedAt,
+			})
+		}
+		data.Games = append(data.Games, gameData)
+	}
+	for _, achievement := range profile.Recent {
+		data.Recent = append(data.Recent, templates.RecentAchievementData{
+			Game:       achievement.Definition.Game.Name,
+			Name:       achievement.Definition.Name,
+			UnlockedAt: achievement.CreatedAt,
+		})
+	}
+	if data.Own {
+		friends, err := c.friendService.GetFriends(r.Context(), viewer.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, friend := range friends {
+			data.Friends = append(data.Friends, friend.Username)
+		}
+		requests, err := c.friendService.GetIncomingRequests(r.Context(), viewer.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, request := range requests {
+			data.IncomingRequests = append(data.IncomingRequests, request.Requester.Username)
+		}
+	}
+	err = templates.Render(w, r, templates.ProfileTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type profileResponse struct {
+	Username    string                  `json:"username"`
+	AvatarURL   *string                 `json:"avatar_url"`
+	TotalPoints int                     `json:"total_points"`
+	Games       []profileGameResponse   `json:"games"`
+	Recent      []profileRecentResponse `json:"recent"`
+}
+
+type profileGameResponse struct {
+	Slug         string                       `json:"slug"`
+	Name         string                       `json:"name"`
+	IconURL      string                       `json:"icon_url"`
+	Points       int                          `json:"points"`
+	Achievements []profileAchievementResponse `json:"achievements"`
+}
+
+type profileAchievementResponse struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IconURL     string    `json:"icon_url"`
+	Points      int       `json:"points"`
+	UnlockedAt  time.Time `json:"unlocked_at"`
+}
+
+type profileRecentResponse struct {
+	Game       string    `json:"game"`
+	Key        string    `json:"key"`
+	Name       string    `json:"name"`
+	UnlockedAt time.Time `json:"unlocked_at"`
+}
+
+type profileErrorResponse struct {
+	Message string `json:"message"`
+}
+
+func (c *ProfileController) jsonResponse(w http.ResponseWriter, data any, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+// GetProfileJSON is the read-only API version of GetProfile. It honours the
+// same privacy settings, using the session cookie if there is one.
+func (c *ProfileController) GetProfileJSON(w http.ResponseWriter, r *http.Request) {
+	viewer := middleware.UserFromContext(r.Context())
+	profile, err := c.profileService.GetProfile(r.Context(), viewer, r.PathValue("username"))
+	if errors.Is(err, services.ErrProfileNotFound) {
+		c.jsonResponse(w, profileErrorResponse{Message: "Profile not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.jsonResponse(w, profileErrorResponse{Message: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	if profile.Hidden {
+		c.jsonResponse(w, profileErrorResponse{Message: "Profile is private"}, http.StatusForbidden)
+		return
+	}
+	response := profileResponse{
+		Username:    profile.User.Username,
+		TotalPoints: profile.TotalPoints,
+		Games:       make([]profileGameResponse, 0, len(profile.Games)),
+		Recent:      make([]profileRecentResponse, 0, len(profile.Recent)),
+	}
+	if avatarURL := c.avatarService.URL(profile.User, 256); avatarURL != "" {
+		response.AvatarURL = &avatarURL
+	}
+	for _, game := range profile.Games {
+		gameResponse := profileGameResponse{
+			Slug:         game.Game.Slug,
+			Name:         game.Game.Name,
+			IconURL:      game.Game.IconURL,
+			Points:       game.Points,
+			Achievements: make([]profileAchievementResponse, 0, len(game.Achievements)),
+		}
+		for _, achievement := range game.Achievements {
+			gameResponse.Achievements = append(gameResponse.Achievements, profileAchievementResponse{
+				Key:         achievement.Definition.Key,
+				Name:        achievement.Definition.Name,
+				Description: achievement.Definition.Description,
+				IconURL:     achievement.Definition.IconURL,
+				Points:      achievement.Definition.Points,
+				UnlockedAt:  achievement.CreatedAt,
+			})
+		}
+		response.Games = append(response.Games, gameResponse)
+	}
+	for _, achievement := range profile.Recent {
+		response.Recent = append(response.Recent, profileRecentResponse{
+			Game:       achievement.Definition.Game.Slug,
+			Key:        achievement.Definition.Key,
+			Name:       achievement.Definition.Name,
+			UnlockedAt: achievement.CreatedAt,
+		})
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	c.jsonResponse(w, response, http.StatusOK)
+}
+
+// profileUser looks up the user named in the path, writing a 404 if there
+// is none.
+func (c *ProfileController) profileUser(w http.ResponseWriter, r *http.Request) *repository.User {
+	user, err := c.userService.GetUserByUsername(r.Context(), r.PathValue("username"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	return user
+}
+
+func (c *ProfileController) PostFriend(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	other := c.profileUser(w, r)
+	if other == nil {
+		return
+	}
+	err := c.friendService.AddFriend(r.Context(), user, other)
+	if err != nil && !errors.Is(err, services.ErrCannotFriendSelf) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.redirectAfterFriendAction(w, r, user, other)
+}
+
+func (c *ProfileController) PostUnfriend(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	other := c.profileUser(w, r)
+	if other == nil {
+		return
+	}
+	if err := c.friendService.RemoveFriend(r.Context(), user, other); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.redirectAfterFriendAction(w, r, user, other)
+}
+
+// redirectAfterFriendAction goes back to the other user's profile, or to the
+// user's own when the request list there was used.
+func (c *ProfileController) redirectAfterFriendAction(w http.ResponseWriter, r *http.Request, user *repository.User, other *repository.User) {
+	if r.FormValue("from") == "own" {
+		http.Redirect(w, r, profileURL(user.Username), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, profileURL(other.Username), http.StatusSeeOther)
 }
 
 func (c *ProfileController) Logout(w http.ResponseWriter, r *http.Request) {
@@ -21,5 +267,7 @@ func (c *ProfileController) Logout(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to logout", http.StatusInternalServerError)
 		return
 	}
+	middleware.ClearSessionCookie(w)
+	middleware.RotateCSRFToken(w)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
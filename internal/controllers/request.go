@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+)
+
+const maxDeviceInfoLength = 200
+
+// deviceInfo describes the device a game is running on. Games may send a
+// device_name such as "PlayStation 5"; otherwise the User-Agent is used.
+func deviceInfo(r *http.Request) string {
+	info := strings.TrimSpace(r.FormValue("device_name"))
+	if info == "" {
+		info = r.UserAgent()
+	}
+	if len(info) > maxDeviceInfoLength {
+		info = info[:maxDeviceInfoLength]
+	}
+	return strings.ToValidUTF8(info, "")
+}
@@ -0,0 +1,408 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"gt/internal/middleware"
+	"gt/internal/qrcode"
+	"gt/internal/repository"
+	"gt/internal/services"
+	"gt/internal/templates"
+	"gt/internal/useragent"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+)
+
+type SettingsController struct {
+	authService      *services.AuthService
+	userService      *services.UserService
+	accountService   *services.AccountService
+	profileService   *services.ProfileService
+	avatarService    *services.AvatarService
+	gameService      *services.GameService
+	twoFactorService *services.TwoFactorService
+}
+
+func NewSettingsController(authService *services.AuthService, userService *services.UserService, accountService *services.AccountService, profileService *services.ProfileService, avatarService *services.AvatarService, gameService *services.GameService, twoFactorService *services.TwoFactorService) *SettingsController {
+	return &SettingsController{
+		authService:      authService,
+		userService:      userService,
+		accountService:   accountService,
+		profileService:   profileService,
+		avatarService:    avatarService,
+		gameService:      gameService,
+		twoFactorService: twoFactorService,
+	}
+}
+
+func (c *SettingsController) GetSettings(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+}
+
+// renderAccount renders the account page for the current user. Callers set
+// Notice and Error.
+func (c *SettingsController) renderAccount(w http.ResponseWriter, r *http.Request, data templates.SettingsAccountData) {
+	user := middleware.UserFromContext(r.Context())
+	data.AuthenticatedData = templates.AuthenticatedData{User: user}
+	data.UsernameCooldownUntil = c.userService.UsernameCooldownUntil(user)
+	data.DeletionGracePeriod = formatGracePeriod(c.accountService.DeletionGracePeriod())
+	data.AvatarURL = c.avatarService.URL(user, 128)
+	err := templates.Render(w, r, templates.SettingsAccountTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *SettingsController) GetAccount(w http.ResponseWriter, r *http.Request) {
+	c.renderAccount(w, r, templates.SettingsAccountData{})
+}
+
+func (c *SettingsController) PostUsername(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	err := c.userService.ChangeUsername(r.Context(), user, r.FormValue("username"))
+	var validationErr *services.ValidationError
+	var cooldownErr *services.UsernameCooldownError
+	switch {
+	case errors.As(err, &validationErr):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: validationErr.Message})
+	case errors.As(err, &cooldownErr):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "You can change your username again after " + cooldownErr.Until.Format("2006-01-02 15:04") + "."})
+	case errors.Is(err, services.ErrUsernameTaken):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "Username is already taken"})
+	case errors.Is(err, services.ErrUsernameUnchanged):
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	}
+}
+
+func (c *SettingsController) PostEmail(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	email := r.FormValue("email")
+	err := c.userService.RequestEmailChange(r.Context(), user, r.FormValue("password"), email)
+	var validationErr *services.ValidationError
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "Incorrect password"})
+	case errors.As(err, &validationErr):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: validationErr.Message})
+	case errors.Is(err, services.ErrEmailTaken):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "An account with this email already exists"})
+	case errors.Is(err, services.ErrEmailUnchanged):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "That is already your email address"})
+	case errors.Is(err, services.ErrTooManyAttempts):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "Too many emails sent. Please try again later."})
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		c.renderAccount(w, r, templates.SettingsAccountData{Notice: "We sent a confirmation link to " + services.NormalizeEmail(email) + ". Your email will change once you open it."})
+	}
+}
+
+func (c *SettingsController) PostPassword(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	session := middleware.SessionFromContext(r.Context())
+	err := c.userService.ChangePassword(r.Context(), user, session.ID, r.FormValue("current_password"), r.FormValue("new_password"))
+	var policyErr *services.PasswordPolicyError
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "Incorrect password"})
+	case errors.As(err, &policyErr):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: policyErr.Message})
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		c.renderAccount(w, r, templates.SettingsAccountData{Notice: "Your password was changed and your other sessions were signed out."})
+	}
+}
+
+func (c *SettingsController) GetGames(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	gameLogins, err := c.gameService.GetActiveGameLogins(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = templates.Render(w, r, templates.SettingsGamesTemplate, templates.SettingsGamesData{
+		AuthenticatedData: templates.AuthenticatedData{User: user},
+		GameLogins:        gameLogins,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *SettingsController) PostRevokeGame(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	err := c.gameService.RevokeGameLogin(r.Context(), r.PathValue("id"), user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrGameLoginCodeNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	http.Redirect(w, r, "/settings/games", http.StatusSeeOther)
+}
+
+func (c *SettingsController) GetSessions(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	current := middleware.SessionFromContext(r.Context())
+	sessions, err := c.authService.GetSessions(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := templates.SettingsSessionsData{
+		AuthenticatedData: templates.AuthenticatedData{User: user},
+	}
+	for _, session := range sessions {
+		ua := useragent.Parse(session.UserAgent)
+		data.Sessions = append(data.Sessions, templates.SessionData{
+			ID:         session.ID,
+			Browser:    ua.Browser,
+			OS:         ua.OS,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == current.ID,
+		})
+	}
+	err = templates.Render(w, r, templates.SettingsSessionsTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *SettingsController) PostRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	current := middleware.SessionFromContext(r.Context())
+	id := r.PathValue("id")
+	if err := c.authService.RevokeSession(r.Context(), user.ID, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if id == current.ID {
+		middleware.ClearSessionCookie(w)
+		middleware.RotateCSRFToken(w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/settings/sessions", http.StatusSeeOther)
+}
+
+func (c *SettingsController) PostRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	current := middleware.SessionFromContext(r.Context())
+	if err := c.authService.RevokeOtherSessions(r.Context(), user.ID, current.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings/sessions", http.StatusSeeOther)
+}
+
+func (c *SettingsController) PostAvatar(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxAvatarBytes+1<<16)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			c.renderAccount(w, r, templates.SettingsAccountData{Error: services.ErrAvatarTooLarge.Error()})
+		case errors.Is(err, http.ErrMissingFile):
+			c.renderAccount(w, r, templates.SettingsAccountData{Error: "Choose an image to upload"})
+		default:
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+	err = c.avatarService.Upload(r.Context(), user, file)
+	switch {
+	case errors.Is(err, services.ErrAvatarTooLarge), errors.Is(err, services.ErrAvatarNotAnImage), errors.Is(err, services.ErrAvatarTooManyPixels):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: err.Error()})
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	}
+}
+
+func (c *SettingsController) PostRemoveAvatar(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	if err := c.avatarService.Remove(r.Context(), user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+}
+
+func (c *SettingsController) PostProfileVisibility(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	err := c.profileService.UpdateVisibility(r.Context(), user, repository.ProfileVisibility(r.FormValue("visibility")))
+	switch {
+	case errors.Is(err, services.ErrInvalidProfileVisibility):
+		http.Error(w, "Invalid profile visibility", http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	}
+}
+
+func (c *SettingsController) PostExport(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	export, err := c.accountService.Export(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filename := "gt-" + user.Username + "-" + export.ExportedAt.Format("20060102")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.FormValue("format") == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + ".zip"}))
+		err = export.WriteZIP(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + ".json"}))
+		err = export.WriteJSON(w)
+	}
+	if err != nil {
+		// The headers are already sent, so all that's left is to log it.
+		log.Printf("failed to write data export for user %s: %v", user.ID, err)
+	}
+}
+
+func (c *SettingsController) PostDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	session := middleware.SessionFromContext(r.Context())
+	err := c.accountService.ScheduleDeletion(r.Context(), user, session.ID, r.FormValue("password"))
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: "Incorrect password"})
+	case errors.Is(err, services.ErrOwnsGames):
+		c.renderAccount(w, r, templates.SettingsAccountData{Error: err.Error()})
+	case errors.Is(err, services.ErrDeletionAlreadyScheduled):
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	}
+}
+
+func (c *SettingsController) PostCancelDeletion(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	err := c.accountService.CancelDeletion(r.Context(), user)
+	if err != nil && !errors.Is(err, services.ErrDeletionNotScheduled) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+}
+
+func formatGracePeriod(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	if d >= 2*time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return formatRetryAfter(d)
+}
+
+// renderSecurity fills in the two-factor state of the user and renders the
+// security page. Callers set RecoveryCodes and Error.
+func (c *SettingsController) renderSecurity(w http.ResponseWriter, r *http.Request, data templates.SettingsSecurityData) {
+	user := middleware.UserFromContext(r.Context())
+	data.AuthenticatedData = templates.AuthenticatedData{User: user}
+	data.TOTPEnabled = user.TOTPEnabled
+	if user.TOTPEnabled {
+		remaining, err := c.twoFactorService.CountRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.RemainingRecoveryCodes = remaining
+	} else if user.TOTPSecret != "" {
+		code, err := qrcode.Encode(c.twoFactorService.EnrollmentURI(user))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Enrolling = true
+		data.Secret = user.TOTPSecret
+		data.QRCode = template.HTML(code.SVG())
+	}
+	err := templates.Render(w, r, templates.SettingsSecurityTemplate, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *SettingsController) GetSecurity(w http.ResponseWriter, r *http.Request) {
+	c.renderSecurity(w, r, templates.SettingsSecurityData{})
+}
+
+func (c *SettingsController) PostTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	err := c.twoFactorService.BeginEnrollment(r.Context(), user)
+	if err != nil && !errors.Is(err, services.ErrTOTPAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+}
+
+func (c *SettingsController) PostTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	codes, err := c.twoFactorService.Enable(r.Context(), user, r.FormValue("code"))
+	switch {
+	case errors.Is(err, services.ErrInvalidTOTPCode):
+		c.renderSecurity(w, r, templates.SettingsSecurityData{Error: "That code didn't match. Check your device's clock and try again."})
+	case errors.Is(err, services.ErrTOTPNotEnrolling), errors.Is(err, services.ErrTOTPAlreadyEnabled):
+		http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		c.renderSecurity(w, r, templates.SettingsSecurityData{RecoveryCodes: codes})
+	}
+}
+
+func (c *SettingsController) PostTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	err := c.twoFactorService.Disable(r.Context(), user, r.FormValue("password"))
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		c.renderSecurity(w, r, templates.SettingsSecurityData{Error: "Incorrect password"})
+	case errors.Is(err, services.ErrTOTPNotEnabled):
+		http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+	}
+}
+
+func (c *SettingsController) PostRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context())
+	codes, err := c.twoFactorService.RegenerateRecoveryCodes(r.Context(), user, r.FormValue("password"))
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		c.renderSecurity(w, r, templates.SettingsSecurityData{Error: "Incorrect password"})
+	case errors.Is(err, services.ErrTOTPNotEnabled):
+		http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		c.renderSecurity(w, r, templates.SettingsSecurityData{RecoveryCodes: codes})
+	}
+}
@@ -4,26 +4,28 @@ import (
 	"errors"
 	"gt/internal/services"
 	"gt/internal/templates"
+	"log"
 	"net/http"
 )
 
 type SignupController struct {
-	authService *services.AuthService
+	authService         *services.AuthService
+	verificationService *services.EmailVerificationService
 }
 
-func NewSignupController(authService *services.AuthService) *SignupController {
-	return &SignupController{authService: authService}
+func NewSignupController(authService *services.AuthService, verificationService *services.EmailVerificationService) *SignupController {
+	return &SignupController{authService: authService, verificationService: verificationService}
 }
 
-func (c *SignupController) renderTemplate(w http.ResponseWriter, data *templates.SignupData) {
-	err := templates.SignupTemplate.Execute(w, data)
+func (c *SignupController) renderTemplate(w http.ResponseWriter, r *http.Request, data *templates.SignupData) {
+	err := templates.Render(w, r, templates.SignupTemplate, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func (c *SignupController) GetSignup(w http.ResponseWriter, r *http.Request) {
-	c.renderTemplate(w, &templates.SignupData{})
+	c.renderTemplate(w, r, &templates.SignupData{})
 }
 
 func (c *SignupController) PostSignup(w http.ResponseWriter, r *http.Request) {
@@ -35,22 +37,39 @@ func (c *SignupController) PostSignup(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	email := r.FormValue("email")
 	if username == "" || password == "" || email == "" {
-		c.renderTemplate(w, &templates.SignupData{Error: "Username, email, and password are required"})
+		c.renderTemplate(w, r, &templates.SignupData{
+			Error:    "Username, email, and password are required",
+			Username: username,
+			Email:    email,
+		})
 		return
 	}
-	_, err := c.authService.Signup(r.Context(), services.SignupRequest{
+	user, err := c.authService.Signup(r.Context(), services.SignupRequest{
 		Username: username,
 		Email:    email,
 		Password: password,
 	})
 	if err != nil {
-		var signupErr *services.SignupError
-		if errors.As(err, &signupErr) {
-			c.renderTemplate(w, &templates.SignupData{Error: signupErr.Message})
+		var signupErrs services.SignupErrors
+		if errors.As(err, &signupErrs) {
+			fieldErrors := make(map[string]string, len(signupErrs))
+			for _, signupErr := range signupErrs {
+				fieldErrors[signupErr.Field] = signupErr.Message
+			}
+			c.renderTemplate(w, r, &templates.SignupData{
+				Errors:   fieldErrors,
+				Username: username,
+				Email:    email,
+			})
 			return
 		}
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	// The account is usable without a verified email, and the user can ask
+	// for another link later, so a failed send doesn't fail the signup.
+	if err := c.verificationService.SendVerification(r.Context(), user); err != nil {
+		log.Printf("failed to send verification email to user %s: %v", user.ID, err)
+	}
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
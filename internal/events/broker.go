@@ -0,0 +1,111 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Broker listens on a Postgres NOTIFY channel and wakes up local subscribers
+// waiting for the key carried in the notification payload. Because every
+// server instance runs its own listener, a change made on one replica reaches
+// subscribers connected to any other.
+type Broker struct {
+	dsn         string
+	channel     string
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func NewBroker(dsn string, channel string) *Broker {
+	return &Broker{
+		dsn:         dsn,
+		channel:     channel,
+		subscribers: map[string]map[chan struct{}]struct{}{},
+	}
+}
+
+// Run keeps a LISTEN connection open until ctx is done, reconnecting on errors.
+func (b *Broker) Run(ctx context.Context) {
+	for {
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("events: listener on %s stopped: %v", b.channel, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (b *Broker) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		return err
+	}
+	// Notifications sent while we were disconnected are lost, so make every
+	// subscriber re-check its state.
+	b.wakeAll()
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		b.wake(notification.Payload)
+	}
+}
+
+// Subscribe returns a channel that receives a value whenever key is notified.
+// The returned function must be called to release the subscription.
+func (b *Broker) Subscribe(key string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.subscribers[key] == nil {
+		b.subscribers[key] = map[chan struct{}]struct{}{}
+	}
+	b.subscribers[key][ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[key], ch)
+		if len(b.subscribers[key]) == 0 {
+			delete(b.subscribers, key)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Broker) wake(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[key] {
+		notify(ch)
+	}
+}
+
+func (b *Broker) wakeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			notify(ch)
+		}
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -25,7 +25,7 @@ func SessionFromContext(ctx context.Context) *repository.Session {
 
 func RequireAuth(authService *services.AuthService, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		session := authenticateRequest(r, authService)
+		session := authenticateRequest(w, r, authService)
 		if session == nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
@@ -38,7 +38,7 @@ func RequireAuth(authService *services.AuthService, next http.HandlerFunc) http.
 
 func OptionalAuth(authService *services.AuthService, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		session := authenticateRequest(r, authService)
+		session := authenticateRequest(w, r, authService)
 		if session != nil {
 			ctx := context.WithValue(r.Context(), userContextKey, session.User)
 			ctx = context.WithValue(ctx, sessionContextKey, session)
@@ -50,7 +50,7 @@ func OptionalAuth(authService *services.AuthService, next http.HandlerFunc) http
 
 func NoAuth(authService *services.AuthService, redirectURL string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		session := authenticateRequest(r, authService)
+		session := authenticateRequest(w, r, authService)
 		if session != nil {
 			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 			return
@@ -59,17 +59,24 @@ func NoAuth(authService *services.AuthService, redirectURL string, next http.Han
 	}
 }
 
-func authenticateRequest(r *http.Request, authService *services.AuthService) *repository.Session {
-	cookie, err := r.Cookie("session_id")
+func authenticateRequest(w http.ResponseWriter, r *http.Request, authService *services.AuthService) *repository.Session {
+	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
 		if errors.Is(err, http.ErrNoCookie) {
 			return nil
 		}
 		return nil
 	}
-	session, err := authService.Authenticate(r.Context(), cookie.Value)
-	if err != nil || session == nil {
+	session, err := authService.Authenticate(r.Context(), cookie.Value, ClientIP(r))
+	if err != nil {
+		return nil
+	}
+	if session == nil {
+		ClearSessionCookie(w)
 		return nil
 	}
+	if session.RememberMe {
+		SetSessionCookie(w, session)
+	}
 	return session
 }
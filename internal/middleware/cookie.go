@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"gt/internal/repository"
+	"net/http"
+)
+
+const sessionCookieName = "session_id"
+
+type CookieConfig struct {
+	Secure   bool
+	SameSite http.SameSite
+}
+
+var cookieConfig = CookieConfig{Secure: true, SameSite: http.SameSiteLaxMode}
+
+// ConfigureCookies sets the attributes of the session cookie. Secure can be
+// turned off for local development over plain HTTP.
+func ConfigureCookies(config CookieConfig) {
+	cookieConfig = config
+}
+
+// SetSessionCookie writes the session cookie. "Remember me" sessions get a
+// persistent cookie that follows the server-side idle deadline; others last
+// until the browser is closed.
+func SetSessionCookie(w http.ResponseWriter, session *repository.Session) {
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cookieConfig.Secure,
+		SameSite: cookieConfig.SameSite,
+	}
+	if session.RememberMe {
+		cookie.Expires = session.ExpiresAt
+	}
+	http.SetCookie(w, cookie)
+}
+
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookieConfig.Secure,
+		SameSite: cookieConfig.SameSite,
+	})
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"gt/internal/security"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	CSRFFieldName  = "csrf_token"
+	// maxCSRFFormBytes bounds the url-encoded bodies CSRF parses to find the
+	// token. None of the forms come close.
+	maxCSRFFormBytes = 1 << 20
+	// maxCSRFPartBytes bounds how much of a multipart body CSRF reads to find
+	// the token in its first part.
+	maxCSRFPartBytes = 16 << 10
+)
+
+const csrfContextKey contextKey = "csrf"
+
+// CSRFTokenFromContext returns the token that forms rendered for the current
+// request must send back in the CSRFFieldName field.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey).(string)
+	return token
+}
+
+// CSRF rejects unsafe requests that don't echo the token stored in the
+// csrf_token cookie, either in the X-CSRF-Token header or as a form field.
+// Multipart forms must send the field first: only that part is read here, so
+// uploads stay subject to their handlers' size limits. Paths under the
+// exempt prefixes are skipped; they authenticate with client credentials or
+// bearer tokens rather than cookies.
+func CSRF(next http.Handler, exemptPrefixes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range exemptPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		token := ""
+		if cookie, err := r.Cookie(csrfCookieName); err == nil {
+			token = cookie.Value
+		}
+		if token == "" {
+			token = security.GenerateToken()
+			setCSRFCookie(w, token)
+		}
+
+		if !isSafeMethod(r.Method) {
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" {
+				sent = csrfTokenFromForm(w, r)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), csrfContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// csrfTokenFromForm returns the token sent in the form field of url-encoded
+// and multipart forms.
+func csrfTokenFromForm(w http.ResponseWriter, r *http.Request) string {
+	contentType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/x-www-form-urlencoded":
+		r.Body = http.MaxBytesReader(w, r.Body, maxCSRFFormBytes)
+		if err := r.ParseForm(); err != nil {
+			return ""
+		}
+		return r.PostForm.Get(CSRFFieldName)
+	case "multipart/form-data":
+		return csrfTokenFromFirstPart(r, params["boundary"])
+	}
+	return ""
+}
+
+// csrfTokenFromFirstPart reads the token from the first part of a multipart
+// body, which must be the CSRFFieldName field. The bytes read are put back in
+// front of the body, so the handler parses the whole form as usual.
+func csrfTokenFromFirstPart(r *http.Request, boundary string) string {
+	if boundary == "" {
+		return ""
+	}
+	body := r.Body
+	var consumed bytes.Buffer
+	reader := multipart.NewReader(io.TeeReader(io.LimitReader(body, maxCSRFPartBytes), &consumed), boundary)
+	token := ""
+	if part, err := reader.NextPart(); err == nil && part.FormName() == CSRFFieldName && part.FileName() == "" {
+		value, err := io.ReadAll(part)
+		if err == nil {
+			token = string(value)
+		}
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&consumed, body), body}
+	return token
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// RotateCSRFToken issues a new token. It is called whenever the user signs in
+// or out, so a token obtained before that, e.g. by an attacker who planted the
+// cookie, is no good afterwards.
+func RotateCSRFToken(w http.ResponseWriter) {
+	setCSRFCookie(w, security.GenerateToken())
+}
+
+func setCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cookieConfig.Secure,
+		SameSite: cookieConfig.SameSite,
+	})
+}
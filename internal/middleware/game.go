@@ -5,6 +5,7 @@ import (
 	"gt/internal/repository"
 	"gt/internal/services"
 	"net/http"
+	"strings"
 )
 
 const gameLoginContextKey contextKey = "gamelogin"
@@ -16,13 +17,19 @@ func GameLoginFromContext(ctx context.Context) *repository.GameLogin {
 
 func RequireGameLogin(gameService *services.GameService, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.Header.Get("X-Game-Login-ID")
-		token := r.Header.Get("X-Game-Login-Token")
-		if id == "" || token == "" {
-			http.Error(w, "Missing game login credentials", http.StatusUnauthorized)
-			return
+		var gameLogin *repository.GameLogin
+		var err error
+		if accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			gameLogin, err = gameService.AuthenticateAccessToken(r.Context(), accessToken)
+		} else {
+			id := r.Header.Get("X-Game-Login-ID")
+			token := r.Header.Get("X-Game-Login-Token")
+			if id == "" || token == "" {
+				http.Error(w, "Missing game login credentials", http.StatusUnauthorized)
+				return
+			}
+			gameLogin, err = gameService.AuthenticateGameLogin(r.Context(), id, token)
 		}
-		gameLogin, err := gameService.AuthenticateGameLogin(r.Context(), id, token)
 		if err != nil {
 			http.Error(w, "Invalid game login credentials", http.StatusUnauthorized)
 			return
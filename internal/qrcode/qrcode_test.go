@@ -0,0 +1,118 @@
+package qrcode
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The format information for level M, from the table in ISO/IEC 18004
+// Annex C.
+func TestFormatBits(t *testing.T) {
+	want := []string{
+		"101010000010010",
+		"101000100100101",
+		"101111001111100",
+		"101101101001011",
+		"100010111111001",
+		"100000011001110",
+		"100111110010111",
+		"100101010100000",
+	}
+	for mask, bits := range want {
+		if got := fmt.Sprintf("%015b", formatBits(mask)); got != bits {
+			t.Errorf("formatBits(%d) = %s, want %s", mask, got, bits)
+		}
+	}
+}
+
+// The version information, from the table in ISO/IEC 18004 Annex D.
+func TestVersionBits(t *testing.T) {
+	tests := []struct {
+		version int
+		bits    string
+	}{
+		{7, "000111110010010100"},
+		{8, "001000010110111100"},
+		{9, "001001101010011001"},
+		{10, "001010010011010011"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf("%018b", versionBits(tt.version)); got != tt.bits {
+			t.Errorf("versionBits(%d) = %s, want %s", tt.version, got, tt.bits)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		data    string
+		modules []string
+	}{
+		{
+			// Version 1-M with mask 0.
+			data: "hello",
+			modules: []string{
+				"#######..##...#######",
+				"#.....#.##....#.....#",
+				"#.###.#..#.##.#.###.#",
+				"#.###.#...##..#.###.#",
+				"#.###.#.##..#.#.###.#",
+				"#.....#.....#.#.....#",
+				"#######.#.#.#.#######",
+				"..........###........",
+				"#.#.#.#..#.#....#..#.",
+				"..#.##....#...#....##",
+				".#.#..#.###.#...#####",
+				"##..#.........#....#.",
+				".##.#.##..#.#.#.#....",
+				"........####.#.#..###",
+				"#######...##.###..###",
+				"#.....#...####.##....",
+				"#.###.#.#.##.###...##",
+				"#.###.#..#....##..##.",
+				"#.###.#.###.#...#.#.#",
+				"#.....#..#....#.#..#.",
+				"#######.###.#.##...##",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			c, err := Encode(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Size != len(tt.modules) {
+				t.Fatalf("Size = %d, want %d", c.Size, len(tt.modules))
+			}
+			for y := 0; y < c.Size; y++ {
+				var row strings.Builder
+				for x := 0; x < c.Size; x++ {
+					if c.Dark(x, y) {
+						row.WriteByte('#')
+					} else {
+						row.WriteByte('.')
+					}
+				}
+				if row.String() != tt.modules[y] {
+					t.Errorf("row %d = %s, want %s", y, row.String(), tt.modules[y])
+				}
+			}
+		})
+	}
+}
+
+// Version 10-M holds 216 data codewords, of which 213 are left for bytes.
+func TestEncodeCapacity(t *testing.T) {
+	c, err := Encode(strings.Repeat("a", 213))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.version != 10 {
+		t.Errorf("version = %d, want 10", c.version)
+	}
+	if _, err := Encode(strings.Repeat("a", 214)); err != ErrTooLong {
+		t.Errorf("Encode = %v, want %v", err, ErrTooLong)
+	}
+}
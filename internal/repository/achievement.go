@@ -6,47 +6,16 @@ import (
 
 	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type AchievementName string
-
-const (
-	AchievementFirstLogin = AchievementName("first_login")
-)
-
-func (a AchievementName) IsValid() bool {
-	switch a {
-	case AchievementFirstLogin:
-		return true
-	default:
-		return false
-	}
-}
-
-func (a AchievementName) String() string {
-	switch a {
-	case AchievementFirstLogin:
-		return "First Login"
-	default:
-		return "Unknown Achievement"
-	}
-}
-
-func (a AchievementName) ImageURL() string {
-	switch a {
-	case AchievementFirstLogin:
-		return "/public/img/first.png"
-	default:
-		return ""
-	}
-}
-
 type Achievement struct {
-	ID        string    `gorm:"primaryKey"`
-	UserID    string    `gorm:"index,not null"`
-	Name      string    `gorm:"not null"`
-	User      *User     `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
-	CreatedAt time.Time `gorm:"not null"`
+	ID           string                 `gorm:"primaryKey"`
+	UserID       string                 `gorm:"not null;uniqueIndex:idx_achievements_user_definition"`
+	DefinitionID string                 `gorm:"not null;uniqueIndex:idx_achievements_user_definition;index"`
+	User         *User                  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Definition   *AchievementDefinition `gorm:"foreignKey:DefinitionID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CreatedAt    time.Time              `gorm:"not null"`
 }
 
 type AchievementRepository struct {
@@ -58,35 +27,48 @@ func NewAchievementRepository(db *gorm.DB) *AchievementRepository {
 }
 
 type CreateAchievementRequest struct {
-	UserID string
-	Name   AchievementName
+	UserID       string
+	DefinitionID string
 }
 
+// Create unlocks the achievement for the user. It returns nil if they have
+// already unlocked it.
 func (r *AchievementRepository) Create(ctx context.Context, req *CreateAchievementRequest) (*Achievement, error) {
 	achievement := &Achievement{
-		ID:        ulid.Make().String(),
-		UserID:    req.UserID,
-		Name:      string(req.Name),
-		CreatedAt: time.Now(),
+		ID:           ulid.Make().String(),
+		UserID:       req.UserID,
+		DefinitionID: req.DefinitionID,
+		CreatedAt:    time.Now(),
 	}
-	if err := r.db.WithContext(ctx).Create(achievement).Error; err != nil {
-		return nil, err
+	result := r.db.WithContext(ctx).Clauses(onAchievementConflict).Create(achievement)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
 	}
 	return achievement, nil
 }
 
+// onAchievementConflict skips unlocks the user already has, relying on the
+// unique index rather than a separate check that could race.
+var onAchievementConflict = clause.OnConflict{
+	Columns:   []clause.Column{{Name: "user_id"}, {Name: "definition_id"}},
+	DoNothing: true,
+}
+
 func (r *AchievementRepository) GetByUserID(ctx context.Context, userID string) ([]*Achievement, error) {
 	var achievements []*Achievement
-	err := r.db.WithContext(ctx).Preload("User").Where("user_id = ?", userID).Find(&achievements).Error
+	err := r.db.WithContext(ctx).Preload("User").Preload("Definition.Game").Where("user_id = ?", userID).Order("created_at DESC").Find(&achievements).Error
 	if err != nil {
 		return nil, err
 	}
 	return achievements, nil
 }
 
-func (r *AchievementRepository) Contains(ctx context.Context, userID string, name AchievementName) (bool, error) {
+func (r *AchievementRepository) Contains(ctx context.Context, userID string, definitionID string) (bool, error) {
 	var count int64
-	err := r.db.WithContext(ctx).Model(&Achievement{}).Where("user_id = ? AND name = ?", userID, string(name)).Count(&count).Error
+	err := r.db.WithContext(ctx).Model(&Achievement{}).Where("user_id = ? AND definition_id = ?", userID, definitionID).Count(&count).Error
 	if err != nil {
 		return false, err
 	}
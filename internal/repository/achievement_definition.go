@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// AchievementDefinition is an achievement a game offers. Progress
+// achievements, like "kill 100 enemies", have a Target the player's reported
+// progress must reach to unlock them; for the rest it is 0.
+type AchievementDefinition struct {
+	ID          string `gorm:"primaryKey"`
+	GameID      string `gorm:"uniqueIndex:idx_achievement_definitions_game_key;not null"`
+	Key         string `gorm:"uniqueIndex:idx_achievement_definitions_game_key;not null"`
+	Name        string `gorm:"not null"`
+	Description string `gorm:"not null"`
+	IconURL     string `gorm:"not null"`
+	Points      int    `gorm:"not null;default:0"`
+	Target      int    `gorm:"not null;default:0"`
+	Hidden      bool   `gorm:"not null;default:false"`
+	Retired     bool   `gorm:"not null;default:false"`
+	Game        *Game  `gorm:"foreignKey:GameID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type AchievementDefinitionRepository struct {
+	db *gorm.DB
+}
+
+func NewAchievementDefinitionRepository(db *gorm.DB) *AchievementDefinitionRepository {
+	return &AchievementDefinitionRepository{db: db}
+}
+
+type CreateAchievementDefinitionRequest struct {
+	GameID      string
+	Key         string
+	Name        string
+	Description string
+	IconURL     string
+	Points      int
+	Target      int
+	Hidden      bool
+}
+
+func (r *AchievementDefinitionRepository) Create(ctx context.Context, req *CreateAchievementDefinitionRequest) (*AchievementDefinition, error) {
+	definition := &AchievementDefinition{
+		ID:          ulid.Make().String(),
+		GameID:      req.GameID,
+		Key:         req.Key,
+		Name:        req.Name,
+		Description: req.Description,
+		IconURL:     req.IconURL,
+		Points:      req.Points,
+		Target:      req.Target,
+		Hidden:      req.Hidden,
+	}
+	if err := r.db.WithContext(ctx).Create(definition).Error; err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+func (r *AchievementDefinitionRepository) GetByID(ctx context.Context, id string) (*AchievementDefinition, error) {
+	var definition AchievementDefinition
+	err := r.db.WithContext(ctx).Preload("Game").Where("id = ?", id).First(&definition).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &definition, nil
+}
+
+func (r *AchievementDefinitionRepository) GetByKey(ctx context.Context, gameID string, key string) (*AchievementDefinition, error) {
+	var definition AchievementDefinition
+	err := r.db.WithContext(ctx).Preload("Game").Where("game_id = ? AND key = ?", gameID, key).First(&definition).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &definition, nil
+}
+
+func (r *AchievementDefinitionRepository) GetByGameID(ctx context.Context, gameID string) ([]*AchievementDefinition, error) {
+	var definitions []*AchievementDefinition
+	err := r.db.WithContext(ctx).Where("game_id = ?", gameID).Order("key").Find(&definitions).Error
+	if err != nil {
+		return nil, err
+	}
+	return definitions, nil
+}
+
+func (r *AchievementDefinitionRepository) Update(ctx context.Context, definition *AchievementDefinition) error {
+	return r.db.WithContext(ctx).Save(definition).Error
+}
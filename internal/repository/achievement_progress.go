@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AchievementProgress is how far a user has got towards a progress
+// achievement, i.e. one whose definition has a target.
+type AchievementProgress struct {
+	UserID       string                 `gorm:"primaryKey"`
+	DefinitionID string                 `gorm:"primaryKey;index"`
+	Value        int                    `gorm:"not null;default:0"`
+	UpdatedAt    time.Time              `gorm:"not null"`
+	User         *User                  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Definition   *AchievementDefinition `gorm:"foreignKey:DefinitionID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type AchievementProgressRepository struct {
+	db *gorm.DB
+}
+
+func NewAchievementProgressRepository(db *gorm.DB) *AchievementProgressRepository {
+	return &AchievementProgressRepository{db: db}
+}
+
+type RecordAchievementProgressRequest struct {
+	UserID       string
+	DefinitionID string
+	// Value replaces the stored progress, or is added to it if Increment is
+	// set.
+	Value     int
+	Increment bool
+	// Target is the progress at which the achievement unlocks.
+	Target int
+}
+
+// Record stores the progress and, once it reaches the target, unlocks the
+// achievement in the same transaction. The unlock is skipped if the user
+// already has the achievement, so it is returned only if this call unlocked
+// it.
+func (r *AchievementProgressRepository) Record(ctx context.Context, req *RecordAchievementProgressRequest) (*AchievementProgress, *Achievement, error) {
+	var progress AchievementProgress
+	var unlocked *Achievement
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		value := clause.Expr{SQL: "excluded.value"}
+		if req.Increment {
+			value = clause.Expr{SQL: "achievement_progresses.value + excluded.value"}
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "definition_id"}},
+			DoUpdates: clause.Set{{Column: clause.Column{Name: "value"}, Value: value}, {Column: clause.Column{Name: "updated_at"}, Value: now}},
+		}).Create(&AchievementProgress{
+			UserID:       req.UserID,
+			DefinitionID: req.DefinitionID,
+			Value:        req.Value,
+			UpdatedAt:    now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ? AND definition_id = ?", req.UserID, req.DefinitionID).First(&progress).Error
+		if err != nil {
+			return err
+		}
+		if progress.Value < req.Target {
+			return nil
+		}
+		achievement := &Achievement{
+			ID:           ulid.Make().String(),
+			UserID:       req.UserID,
+			DefinitionID: req.DefinitionID,
+			CreatedAt:    now,
+		}
+		result := tx.Clauses(onAchievementConflict).Create(achievement)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			unlocked = achievement
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &progress, unlocked, nil
+}
+
+// GetInProgressByUserID returns the user's progress on achievements they
+// have not unlocked yet, most recently updated first.
+func (r *AchievementProgressRepository) GetInProgressByUserID(ctx context.Context, userID string) ([]*AchievementProgress, error) {
+	var progress []*AchievementProgress
+	err := r.db.WithContext(ctx).Preload("Definition.Game").
+		Where("user_id = ?", userID).
+		Where("NOT EXISTS (SELECT 1 FROM achievements WHERE achievements.user_id = achievement_progresses.user_id AND achievements.definition_id = achievement_progresses.definition_id)").
+		Order("updated_at DESC").
+		Find(&progress).Error
+	if err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
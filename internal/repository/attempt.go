@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// Attempt records a single failed attempt of a rate-limited action, keyed by
+// whatever the limiter is tracking (an IP, a user, an account name).
+type Attempt struct {
+	ID        string    `gorm:"primaryKey"`
+	Key       string    `gorm:"index:idx_attempts_key_created_at;not null"`
+	CreatedAt time.Time `gorm:"index:idx_attempts_key_created_at;not null"`
+}
+
+type AttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewAttemptRepository(db *gorm.DB) *AttemptRepository {
+	return &AttemptRepository{db: db}
+}
+
+func (r *AttemptRepository) Create(ctx context.Context, key string) (*Attempt, error) {
+	attempt := &Attempt{
+		ID:        ulid.Make().String(),
+		Key:       key,
+		CreatedAt: time.Now(),
+	}
+	if err := r.db.WithContext(ctx).Create(attempt).Error; err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+func (r *AttemptRepository) CountSince(ctx context.Context, key string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&Attempt{}).Where("key = ? AND created_at > ?", key, since).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// StatsSince returns how many attempts were recorded for key after since and
+// when the latest of them happened.
+func (r *AttemptRepository) StatsSince(ctx context.Context, key string, since time.Time) (int64, time.Time, error) {
+	var stats struct {
+		Count  int64
+		Latest *time.Time
+	}
+	err := r.db.WithContext(ctx).Model(&Attempt{}).
+		Select("COUNT(*) AS count, MAX(created_at) AS latest").
+		Where("key = ? AND created_at > ?", key, since).
+		Scan(&stats).Error
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if stats.Latest == nil {
+		return stats.Count, time.Time{}, nil
+	}
+	return stats.Count, *stats.Latest, nil
+}
+
+func (r *AttemptRepository) DeleteByKey(ctx context.Context, key string) error {
+	return r.db.WithContext(ctx).Where("key = ?", key).Delete(&Attempt{}).Error
+}
+
+// DeleteBefore deletes up to limit attempts recorded before the given time.
+func (r *AttemptRepository) DeleteBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ids := r.db.Model(&Attempt{}).Select("id").Where("created_at < ?", before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&Attempt{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// EmailVerification is a single-use proof that the user received mail at
+// Email. The link sent to the user carries its ID signed by the server.
+// When Change is set, Email is a new address the user asked to switch to
+// rather than their current one.
+type EmailVerification struct {
+	ID        string     `gorm:"primaryKey"`
+	UserID    string     `gorm:"index;not null"`
+	Email     string     `gorm:"not null"`
+	Change    bool       `gorm:"not null;default:false"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+	User      *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type EmailVerificationRepository struct {
+	db *gorm.DB
+}
+
+func NewEmailVerificationRepository(db *gorm.DB) *EmailVerificationRepository {
+	return &EmailVerificationRepository{db: db}
+}
+
+type CreateEmailVerificationRequest struct {
+	UserID    string
+	Email     string
+	Change    bool
+	ExpiresAt time.Time
+}
+
+func (r *EmailVerificationRepository) Create(ctx context.Context, req *CreateEmailVerificationRequest) (*EmailVerification, error) {
+	verification := &EmailVerification{
+		ID:        ulid.Make().String(),
+		UserID:    req.UserID,
+		Email:     req.Email,
+		Change:    req.Change,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := r.db.WithContext(ctx).Create(verification).Error; err != nil {
+		return nil, err
+	}
+	return verification, nil
+}
+
+func (r *EmailVerificationRepository) GetByID(ctx context.Context, id string) (*EmailVerification, error) {
+	var verification EmailVerification
+	err := r.db.WithContext(ctx).Preload("User").Where("id = ?", id).First(&verification).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &verification, nil
+}
+
+// MarkUsed atomically marks the verification as used. It reports false if it
+// had already been used.
+func (r *EmailVerificationRepository) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&EmailVerification{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkChangesUsedByUserID invalidates every outstanding email change of the
+// user, so only the latest requested address can be confirmed.
+func (r *EmailVerificationRepository) MarkChangesUsedByUserID(ctx context.Context, userID string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&EmailVerification{}).Where("user_id = ? AND change = true AND used_at IS NULL", userID).Update("used_at", at).Error
+}
+
+// DeleteExpired deletes up to limit verifications that expired before the
+// given time.
+func (r *EmailVerificationRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ids := r.db.Model(&EmailVerification{}).Select("id").Where("expires_at < ?", before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&EmailVerification{})
+	return result.RowsAffected, result.Error
+}
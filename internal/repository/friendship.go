@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Friendship is a friend request from RequesterID to AddresseeID. The two
+// are friends once it is accepted.
+type Friendship struct {
+	RequesterID string     `gorm:"primaryKey"`
+	AddresseeID string     `gorm:"primaryKey;index"`
+	CreatedAt   time.Time  `gorm:"not null"`
+	AcceptedAt  *time.Time `gorm:"default:null"`
+	Requester   *User      `gorm:"foreignKey:RequesterID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Addressee   *User      `gorm:"foreignKey:AddresseeID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type FriendshipRepository struct {
+	db *gorm.DB
+}
+
+func NewFriendshipRepository(db *gorm.DB) *FriendshipRepository {
+	return &FriendshipRepository{db: db}
+}
+
+func (r *FriendshipRepository) Create(ctx context.Context, requesterID string, addresseeID string) (*Friendship, error) {
+	friendship := &Friendship{
+		RequesterID: requesterID,
+		AddresseeID: addresseeID,
+		CreatedAt:   time.Now(),
+	}
+	if err := r.db.WithContext(ctx).Create(friendship).Error; err != nil {
+		return nil, err
+	}
+	return friendship, nil
+}
+
+// GetBetween returns the request between the two users in either direction,
+// if there is one.
+func (r *FriendshipRepository) GetBetween(ctx context.Context, userID string, otherID string) (*Friendship, error) {
+	var friendship Friendship
+	err := r.db.WithContext(ctx).
+		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", userID, otherID, otherID, userID).
+		First(&friendship).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &friendship, nil
+}
+
+// Accept accepts the pending request from requesterID to addresseeID. It
+// reports false if there is no such pending request.
+func (r *FriendshipRepository) Accept(ctx context.Context, requesterID string, addresseeID string, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&Friendship{}).
+		Where("requester_id = ? AND addressee_id = ? AND accepted_at IS NULL", requesterID, addresseeID).
+		Update("accepted_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteBetween removes the friendship or request between the two users in
+// either direction.
+func (r *FriendshipRepository) DeleteBetween(ctx context.Context, userID string, otherID string) error {
+	return r.db.WithContext(ctx).
+		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", userID, otherID, otherID, userID).
+		Delete(&Friendship{}).Error
+}
+
+// GetFriends returns the users the user is friends with, by username.
+func (r *FriendshipRepository) GetFriends(ctx context.Context, userID string) ([]*User, error) {
+	var users []*User
+	err := r.db.WithContext(ctx).
+		Where("id IN (SELECT addressee_id FROM friendships WHERE requester_id = ? AND accepted_at IS NOT NULL)", userID).
+		Or("id IN (SELECT requester_id FROM friendships WHERE addressee_id = ? AND accepted_at IS NOT NULL)", userID).
+		Order("username").
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetIncoming returns the pending requests sent to the user, newest first.
+func (r *FriendshipRepository) GetIncoming(ctx context.Context, userID string) ([]*Friendship, error) {
+	var friendships []*Friendship
+	err := r.db.WithContext(ctx).Preload("Requester").
+		Where("addressee_id = ? AND accepted_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&friendships).Error
+	if err != nil {
+		return nil, err
+	}
+	return friendships, nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+type Game struct {
+	ID           string `gorm:"primaryKey"`
+	OwnerID      string `gorm:"index;not null"`
+	Slug         string `gorm:"uniqueIndex;not null"`
+	Name         string `gorm:"not null"`
+	ClientID     string `gorm:"uniqueIndex;not null"`
+	ClientSecret string `gorm:"not null"`
+	IconURL      string `gorm:"not null;default:''"`
+	// WebhookURL receives account events for players linked to the game,
+	// signed with WebhookSecret.
+	WebhookURL    string    `gorm:"not null;default:''"`
+	WebhookSecret string    `gorm:"not null;default:''"`
+	CreatedAt     time.Time `gorm:"not null"`
+	// Deleting an owner is refused while they have games, since it would take
+	// every player's achievements for those games with it.
+	Owner *User `gorm:"foreignKey:OwnerID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
+}
+
+type GameRepository struct {
+	db *gorm.DB
+}
+
+func NewGameRepository(db *gorm.DB) *GameRepository {
+	return &GameRepository{db: db}
+}
+
+type CreateGameRequest struct {
+	OwnerID      string
+	Slug         string
+	Name         string
+	IconURL      string
+	ClientID     string
+	ClientSecret string
+}
+
+func (r *GameRepository) Create(ctx context.Context, req *CreateGameRequest) (*Game, error) {
+	game := &Game{
+		ID:           ulid.Make().String(),
+		OwnerID:      req.OwnerID,
+		Slug:         req.Slug,
+		Name:         req.Name,
+		IconURL:      req.IconURL,
+		ClientID:     req.ClientID,
+		ClientSecret: req.ClientSecret,
+		CreatedAt:    time.Now(),
+	}
+	if err := r.db.WithContext(ctx).Create(game).Error; err != nil {
+		return nil, err
+	}
+	return game, nil
+}
+
+func (r *GameRepository) GetByID(ctx context.Context, id string) (*Game, error) {
+	var game Game
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&game).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &game, nil
+}
+
+func (r *GameRepository) GetBySlug(ctx context.Context, slug string) (*Game, error) {
+	var game Game
+	err := r.db.WithContext(ctx).Where("slug = ?", slug).First(&game).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &game, nil
+}
+
+func (r *GameRepository) GetByClientID(ctx context.Context, clientID string) (*Game, error) {
+	var game Game
+	err := r.db.WithContext(ctx).Where("client_id = ?", clientID).First(&game).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &game, nil
+}
+
+func (r *GameRepository) GetByOwnerID(ctx context.Context, ownerID string) ([]*Game, error) {
+	var games []*Game
+	err := r.db.WithContext(ctx).Where("owner_id = ?", ownerID).Order("created_at").Find(&games).Error
+	if err != nil {
+		return nil, err
+	}
+	return games, nil
+}
+
+// GetWithWebhookByLinkedUserID returns the games with a webhook URL that the
+// user has ever signed in to.
+func (r *GameRepository) GetWithWebhookByLinkedUserID(ctx context.Context, userID string) ([]*Game, error) {
+	var games []*Game
+	err := r.db.WithContext(ctx).
+		Where("webhook_url <> '' AND id IN (?)", r.db.Model(&GameLogin{}).Select("game_id").Where("user_id = ?", userID)).
+		Find(&games).Error
+	if err != nil {
+		return nil, err
+	}
+	return games, nil
+}
+
+func (r *GameRepository) Update(ctx context.Context, game *Game) error {
+	return r.db.WithContext(ctx).Save(game).Error
+}
@@ -2,16 +2,24 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
 )
 
 type GameLogin struct {
-	ID     string `gorm:"primaryKey"`
-	UserID string `gorm:"index,not null"`
-	Token  string `gorm:"not null"`
-	User   *User  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ID         string     `gorm:"primaryKey"`
+	UserID     string     `gorm:"index,not null"`
+	GameID     string     `gorm:"index,not null"`
+	Token      string     `gorm:"not null"`
+	DeviceInfo string     `gorm:"not null;default:''"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	CreatedAt  time.Time  `gorm:"not null"`
+	LastUsedAt *time.Time `gorm:"default:null"`
+	RevokedAt  *time.Time `gorm:"default:null"`
+	User       *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Game       *Game      `gorm:"foreignKey:GameID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
 type GameLoginRepository struct {
@@ -23,15 +31,22 @@ func NewGameLoginRepository(db *gorm.DB) *GameLoginRepository {
 }
 
 type CreateGameLoginRequest struct {
-	UserID string
-	Token  string
+	UserID     string
+	GameID     string
+	Token      string
+	DeviceInfo string
+	ExpiresAt  time.Time
 }
 
 func (r *GameLoginRepository) Create(ctx context.Context, req *CreateGameLoginRequest) (*GameLogin, error) {
 	gameLogin := &GameLogin{
-		ID:     ulid.Make().String(),
-		UserID: req.UserID,
-		Token:  req.Token,
+		ID:         ulid.Make().String(),
+		UserID:     req.UserID,
+		GameID:     req.GameID,
+		Token:      req.Token,
+		DeviceInfo: req.DeviceInfo,
+		ExpiresAt:  req.ExpiresAt,
+		CreatedAt:  time.Now(),
 	}
 	if err := r.db.WithContext(ctx).Create(gameLogin).Error; err != nil {
 		return nil, err
@@ -41,7 +56,7 @@ func (r *GameLoginRepository) Create(ctx context.Context, req *CreateGameLoginRe
 
 func (r *GameLoginRepository) GetByID(ctx context.Context, id string) (*GameLogin, error) {
 	var gameLogin GameLogin
-	err := r.db.WithContext(ctx).Preload("User").Where("id = ?", id).First(&gameLogin).Error
+	err := r.db.WithContext(ctx).Preload("User").Preload("Game").Where("id = ?", id).First(&gameLogin).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
@@ -50,3 +65,64 @@ func (r *GameLoginRepository) GetByID(ctx context.Context, id string) (*GameLogi
 	}
 	return &gameLogin, nil
 }
+
+// GetByUserID returns every login of the user, including revoked and
+// expired ones.
+func (r *GameLoginRepository) GetByUserID(ctx context.Context, userID string) ([]*GameLogin, error) {
+	var gameLogins []*GameLogin
+	err := r.db.WithContext(ctx).Preload("Game").Where("user_id = ?", userID).Order("created_at DESC").Find(&gameLogins).Error
+	if err != nil {
+		return nil, err
+	}
+	return gameLogins, nil
+}
+
+// GetActiveByUserID returns logins that are not revoked and can still be used,
+// either directly or through an unexpired refresh token.
+func (r *GameLoginRepository) GetActiveByUserID(ctx context.Context, userID string) ([]*GameLogin, error) {
+	var gameLogins []*GameLogin
+	now := time.Now()
+	err := r.db.WithContext(ctx).Preload("Game").
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Where("expires_at > ? OR EXISTS (SELECT 1 FROM game_login_refresh_tokens t WHERE t.game_login_id = game_logins.id AND t.used_at IS NULL AND t.expires_at > ?)", now, now).
+		Order("created_at DESC").
+		Find(&gameLogins).Error
+	if err != nil {
+		return nil, err
+	}
+	return gameLogins, nil
+}
+
+// Rotate replaces the access token of a login that is not revoked. It
+// reports false if the login has been revoked in the meantime.
+func (r *GameLoginRepository) Rotate(ctx context.Context, id string, token string, expiresAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&GameLogin{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]any{"token": token, "expires_at": expiresAt})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Revoke revokes the login unless it already is. It reports whether the
+// login was revoked by this call.
+func (r *GameLoginRepository) Revoke(ctx context.Context, id string, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&GameLogin{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *GameLoginRepository) TouchLastUsed(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&GameLogin{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
+
+// RevokeAllByUserID revokes every login of the user that isn't revoked yet.
+func (r *GameLoginRepository) RevokeAllByUserID(ctx context.Context, userID string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&GameLogin{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", at).Error
+}
+
+func (r *GameLoginRepository) CountUsersByGameID(ctx context.Context, gameID string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&GameLogin{}).Where("game_id = ?", gameID).Distinct("user_id").Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
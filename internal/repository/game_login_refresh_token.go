@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// GameLoginRefreshToken is a single-use token that rotates the access token of
+// a GameLogin. All refresh tokens of one GameLogin form a chain.
+type GameLoginRefreshToken struct {
+	ID          string     `gorm:"primaryKey"`
+	GameLoginID string     `gorm:"index;not null"`
+	Token       string     `gorm:"not null"`
+	ExpiresAt   time.Time  `gorm:"not null"`
+	CreatedAt   time.Time  `gorm:"not null"`
+	UsedAt      *time.Time `gorm:"default:null"`
+	GameLogin   *GameLogin `gorm:"foreignKey:GameLoginID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type GameLoginRefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewGameLoginRefreshTokenRepository(db *gorm.DB) *GameLoginRefreshTokenRepository {
+	return &GameLoginRefreshTokenRepository{db: db}
+}
+
+type CreateGameLoginRefreshTokenRequest struct {
+	GameLoginID string
+	Token       string
+	ExpiresAt   time.Time
+}
+
+func (r *GameLoginRefreshTokenRepository) Create(ctx context.Context, req *CreateGameLoginRefreshTokenRequest) (*GameLoginRefreshToken, error) {
+	refreshToken := &GameLoginRefreshToken{
+		ID:          ulid.Make().String(),
+		GameLoginID: req.GameLoginID,
+		Token:       req.Token,
+		ExpiresAt:   req.ExpiresAt,
+		CreatedAt:   time.Now(),
+	}
+	if err := r.db.WithContext(ctx).Create(refreshToken).Error; err != nil {
+		return nil, err
+	}
+	return refreshToken, nil
+}
+
+func (r *GameLoginRefreshTokenRepository) GetByID(ctx context.Context, id string) (*GameLoginRefreshToken, error) {
+	var refreshToken GameLoginRefreshToken
+	err := r.db.WithContext(ctx).Preload("GameLogin").Where("id = ?", id).First(&refreshToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &refreshToken, nil
+}
+
+// MarkUsed atomically marks the token as used. It reports false if the token
+// had already been used, which means it is being replayed.
+func (r *GameLoginRefreshTokenRepository) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&GameLoginRefreshToken{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteExpired deletes up to limit refresh tokens that expired before the
+// given time. Used tokens are kept until then so reuse is still detected.
+func (r *GameLoginRefreshTokenRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ids := r.db.Model(&GameLoginRefreshToken{}).Select("id").Where("expires_at < ?", before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&GameLoginRefreshToken{})
+	return result.RowsAffected, result.Error
+}
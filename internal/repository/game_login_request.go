@@ -7,16 +7,37 @@ import (
 
 	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GameLoginRequestStateChannel is the Postgres NOTIFY channel that carries
+// the ID of a game login request whenever its status changes.
+const GameLoginRequestStateChannel = "game_login_request_state"
+
+type GameLoginRequestStatus string
+
+const (
+	GameLoginRequestPending   = GameLoginRequestStatus("pending")
+	GameLoginRequestApproved  = GameLoginRequestStatus("approved")
+	GameLoginRequestDenied    = GameLoginRequestStatus("denied")
+	GameLoginRequestCancelled = GameLoginRequestStatus("cancelled")
 )
 
 type GameLoginRequest struct {
-	ID          string     `gorm:"primaryKey"`
-	Token       string     `gorm:"uniqueIndex"`
-	UserID      *string    `gorm:"index"`
-	GameLoginID *string    `gorm:"index"`
-	ExpiresAt   time.Time  `gorm:"not null"`
-	User        *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
-	GameLogin   *GameLogin `gorm:"foreignKey:GameLoginID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	ID           string                 `gorm:"primaryKey"`
+	GameID       string                 `gorm:"index;not null"`
+	Token        string                 `gorm:"uniqueIndex"`
+	UserCode     string                 `gorm:"uniqueIndex;not null"`
+	DeviceInfo   string                 `gorm:"not null;default:''"`
+	Status       GameLoginRequestStatus `gorm:"not null;default:pending"`
+	UserID       *string                `gorm:"index"`
+	GameLoginID  *string                `gorm:"index"`
+	Interval     int                    `gorm:"not null"`
+	LastPolledAt *time.Time             `gorm:"default:null"`
+	ExpiresAt    time.Time              `gorm:"not null"`
+	Game         *Game                  `gorm:"foreignKey:GameID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	User         *User                  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	GameLogin    *GameLogin             `gorm:"foreignKey:GameLoginID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
 }
 
 type GameLoginRequestRepository struct {
@@ -28,24 +49,41 @@ func NewGameLoginRequestRepository(db *gorm.DB) *GameLoginRequestRepository {
 }
 
 type CreateGameLoginRequestRequest struct {
-	Token string
+	GameID     string
+	Token      string
+	UserCode   string
+	DeviceInfo string
 }
 
+// Create stores a pending request. It returns nil if the user code is already
+// taken, so the caller can retry with another one.
 func (r *GameLoginRequestRepository) Create(ctx context.Context, req *CreateGameLoginRequestRequest) (*GameLoginRequest, error) {
 	gameLoginRequest := &GameLoginRequest{
-		ID:        ulid.Make().String(),
-		Token:     req.Token,
-		ExpiresAt: time.Now().Add(5 * time.Minute),
+		ID:         ulid.Make().String(),
+		GameID:     req.GameID,
+		Token:      req.Token,
+		UserCode:   req.UserCode,
+		DeviceInfo: req.DeviceInfo,
+		Status:     GameLoginRequestPending,
+		Interval:   5,
+		ExpiresAt:  time.Now().Add(5 * time.Minute),
 	}
-	if err := r.db.WithContext(ctx).Create(gameLoginRequest).Error; err != nil {
-		return nil, err
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_code"}},
+		DoNothing: true,
+	}).Create(gameLoginRequest)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
 	}
 	return gameLoginRequest, nil
 }
 
 func (r *GameLoginRequestRepository) GetByID(ctx context.Context, id string) (*GameLoginRequest, error) {
 	var req GameLoginRequest
-	err := r.db.WithContext(ctx).Preload("User").Preload("GameLogin").Where("id = ?", id).First(&req).Error
+	err := r.db.WithContext(ctx).Preload("Game").Preload("User").Preload("GameLogin").Where("id = ?", id).First(&req).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -55,6 +93,57 @@ func (r *GameLoginRequestRepository) GetByID(ctx context.Context, id string) (*G
 	return &req, nil
 }
 
-func (r *GameLoginRequestRepository) Update(ctx context.Context, req *GameLoginRequest) error {
-	return r.db.WithContext(ctx).Save(req).Error
+func (r *GameLoginRequestRepository) GetByUserCode(ctx context.Context, userCode string) (*GameLoginRequest, error) {
+	var req GameLoginRequest
+	err := r.db.WithContext(ctx).Preload("Game").Preload("User").Preload("GameLogin").Where("user_code = ?", userCode).First(&req).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &req, nil
+}
+
+// UpdateStatus moves the request to status, recording userID if it is set,
+// as long as it is still in one of the from statuses, has not expired and
+// has not been exchanged. It reports false if the request was not updated.
+func (r *GameLoginRequestRepository) UpdateStatus(ctx context.Context, id string, from []GameLoginRequestStatus, status GameLoginRequestStatus, userID *string) (bool, error) {
+	updates := map[string]any{"status": status}
+	if userID != nil {
+		updates["user_id"] = *userID
+	}
+	result := r.db.WithContext(ctx).Model(&GameLoginRequest{}).
+		Where("id = ? AND status IN ? AND expires_at > ? AND game_login_id IS NULL", id, from, time.Now()).
+		Updates(updates)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RecordPoll stores when a device last polled a pending request and the
+// interval it must wait before polling again. Requests that are no longer
+// pending are left alone, so a concurrent approval is never overwritten.
+func (r *GameLoginRequestRepository) RecordPoll(ctx context.Context, id string, at time.Time, interval int) error {
+	return r.db.WithContext(ctx).Model(&GameLoginRequest{}).
+		Where("id = ? AND status = ?", id, GameLoginRequestPending).
+		Updates(map[string]any{"last_polled_at": at, "interval": interval}).Error
+}
+
+// SetGameLogin records the game login an approved request was exchanged for.
+// It reports false if the request has already been exchanged.
+func (r *GameLoginRequestRepository) SetGameLogin(ctx context.Context, id string, gameLoginID string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&GameLoginRequest{}).
+		Where("id = ? AND game_login_id IS NULL", id).
+		Update("game_login_id", gameLoginID)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *GameLoginRequestRepository) NotifyStateChanged(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", GameLoginRequestStateChannel, id).Error
+}
+
+// DeleteExpired deletes up to limit requests that expired before the given time.
+func (r *GameLoginRequestRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ids := r.db.Model(&GameLoginRequest{}).Select("id").Where("expires_at < ?", before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&GameLoginRequest{})
+	return result.RowsAffected, result.Error
 }
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type LockRepository struct {
+	db *gorm.DB
+}
+
+func NewLockRepository(db *gorm.DB) *LockRepository {
+	return &LockRepository{db: db}
+}
+
+// TryWithLock runs fn while holding the Postgres advisory lock identified by
+// key. If another server instance already holds the lock, fn is skipped and
+// false is returned.
+func (r *LockRepository) TryWithLock(ctx context.Context, key int64, fn func() error) (bool, error) {
+	acquired := false
+	err := r.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+		// Unlock with a fresh context so a cancelled run still releases the lock.
+		defer conn.WithContext(context.Background()).Exec("SELECT pg_advisory_unlock(?)", key)
+		return fn()
+	})
+	return acquired, err
+}
@@ -0,0 +1,354 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+var models = []any{
+	&User{}, &Game{}, &Session{}, &GameLogin{}, &GameLoginRequest{}, &GameLoginRefreshToken{},
+	&AchievementDefinition{}, &Achievement{}, &Attempt{}, &EmailVerification{}, &PasswordReset{},
+	&RecoveryCode{}, &UsernameReservation{}, &WebhookDelivery{}, &Friendship{}, &AchievementProgress{},
+}
+
+// migration is a schema or data change AutoMigrate can't make on an existing
+// database by itself. Each one checks whether it still has to run, so all of
+// them are safe to repeat on every start.
+type migration struct {
+	name string
+	run  func(tx *gorm.DB) error
+}
+
+// beforeAutoMigrate runs first, e.g. to fill in data a new constraint needs.
+var beforeAutoMigrate = []migration{
+	{name: "set aside name-based achievements", run: setAsideLegacyAchievements},
+	{name: "remove duplicate achievements", run: removeDuplicateAchievements},
+	{name: "rename usernames that differ only by case", run: renameDuplicateUsernames},
+	{name: "separate shared emails", run: separateDuplicateEmails},
+	{name: "expire sessions without expiry", run: backfillSessionExpiry},
+	{name: "give ownerless games an owner", run: backfillGameOwners},
+	{name: "give games without credentials a client ID", run: backfillGameClientCredentials},
+	{name: "remove login requests without user codes", run: removeLegacyGameLoginRequests},
+	{name: "expire game logins without expiry", run: backfillGameLoginExpiry},
+}
+
+// afterAutoMigrate runs once every table and column exists.
+var afterAutoMigrate = []migration{
+	{name: "restrict deleting game owners", run: restrictGameOwnerDeletion},
+	{name: "move name-based achievements to definitions", run: migrateLegacyAchievements},
+}
+
+// Migrate brings the database schema up to date.
+func Migrate(db *gorm.DB) error {
+	if err := runMigrations(db, beforeAutoMigrate); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		return err
+	}
+	return runMigrations(db, afterAutoMigrate)
+}
+
+func runMigrations(db *gorm.DB, migrations []migration) error {
+	for _, m := range migrations {
+		if err := db.Transaction(m.run); err != nil {
+			return fmt.Errorf("migration %q: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// renameDuplicateUsernames keeps the username of the oldest account among
+// those whose usernames differ only by case, and appends the end of their ID
+// to the others, so the case-insensitive unique index can be created. The
+// renamed users can pick a new username in their settings.
+func renameDuplicateUsernames(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&User{}) || tx.Migrator().HasIndex(&User{}, "idx_users_username_lower") {
+		return nil
+	}
+	result := tx.Exec(`UPDATE users u SET username = left(u.username, 23) || '_' || lower(right(u.id, 8))
+		FROM users o WHERE lower(o.username) = lower(u.username) AND o.id < u.id`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("renamed %d users whose usernames differed only by case", result.RowsAffected)
+	}
+	return nil
+}
+
+// separateDuplicateEmails keeps the email of the oldest account among those
+// sharing one and gives the others a plus address of it, so the unique index
+// can be created. Those addresses are marked unverified, so their users have
+// to confirm or replace them.
+func separateDuplicateEmails(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&User{}) || tx.Migrator().HasIndex(&User{}, "idx_users_email_lower") {
+		return nil
+	}
+	set := `email = CASE WHEN strpos(u.email, '@') > 0
+		THEN regexp_replace(u.email, '@', '+' || lower(right(u.id, 8)) || '@')
+		ELSE u.email || '+' || lower(right(u.id, 8)) END`
+	if tx.Migrator().HasColumn(&User{}, "email_verified") {
+		set += ", email_verified = false"
+	}
+	result := tx.Exec(`UPDATE users u SET ` + set + `
+		FROM users o WHERE lower(o.email) = lower(u.email) AND o.id < u.id`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("gave %d users sharing an email a plus address of it", result.RowsAffected)
+	}
+	return nil
+}
+
+// backfillColumn adds a column that is about to become NOT NULL without a
+// default as nullable if it doesn't exist yet, and fills in rows that lack a
+// value with the SQL expression value, so AutoMigrate can add the constraint.
+func backfillColumn(tx *gorm.DB, table string, column string, sqlType string, value string) error {
+	if !tx.Migrator().HasTable(table) {
+		return nil
+	}
+	if !tx.Migrator().HasColumn(table, column) {
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, sqlType)).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Exec(fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s IS NULL", table, column, value, column)).Error
+}
+
+// backfillSessionExpiry expires sessions created before sessions had an
+// expiry. They never timed out, so there is no expiry they could keep; their
+// users sign in again.
+func backfillSessionExpiry(tx *gorm.DB) error {
+	columns := map[string]string{
+		"last_seen_at":        "created_at",
+		"expires_at":          "now()",
+		"absolute_expires_at": "now()",
+	}
+	for column, value := range columns {
+		if err := backfillColumn(tx, "sessions", column, "timestamptz", value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillGameOwners assigns games created before games had owners, i.e. the
+// seeded example game, to the oldest account. Without any account they can't
+// have players either, so they are deleted.
+func backfillGameOwners(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&Game{}) {
+		return nil
+	}
+	if !tx.Migrator().HasColumn(&Game{}, "owner_id") {
+		if err := tx.Exec("ALTER TABLE games ADD COLUMN owner_id text").Error; err != nil {
+			return err
+		}
+	}
+	var count int64
+	if err := tx.Model(&Game{}).Where("owner_id IS NULL").Count(&count).Error; err != nil || count == 0 {
+		return err
+	}
+	ownerID, err := oldestUserID(tx)
+	if errors.Is(err, errNoUsers) {
+		return tx.Where("owner_id IS NULL").Delete(&Game{}).Error
+	} else if err != nil {
+		return err
+	}
+	return tx.Model(&Game{}).Where("owner_id IS NULL").Update("owner_id", ownerID).Error
+}
+
+// backfillGameClientCredentials gives games created before games had client
+// credentials a random client ID and no usable secret. Their owners issue a
+// secret in the developer portal.
+func backfillGameClientCredentials(tx *gorm.DB) error {
+	if err := backfillColumn(tx, "games", "client_id", "text", "replace(gen_random_uuid()::text, '-', '')"); err != nil {
+		return err
+	}
+	return backfillColumn(tx, "games", "client_secret", "text", "''")
+}
+
+// backfillGameLoginExpiry expires game logins created before they had an
+// expiry. They have no refresh token to renew them with, so their players
+// sign in to the game again.
+func backfillGameLoginExpiry(tx *gorm.DB) error {
+	if err := backfillColumn(tx, "game_logins", "created_at", "timestamptz", "now()"); err != nil {
+		return err
+	}
+	return backfillColumn(tx, "game_logins", "expires_at", "timestamptz", "now()")
+}
+
+// removeLegacyGameLoginRequests deletes login requests made before the
+// device authorization grant. They lack the user code and poll interval it
+// needs and would have expired within minutes anyway.
+func removeLegacyGameLoginRequests(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&GameLoginRequest{}) || tx.Migrator().HasColumn(&GameLoginRequest{}, "user_code") {
+		return nil
+	}
+	return tx.Exec("DELETE FROM game_login_requests").Error
+}
+
+// restrictGameOwnerDeletion replaces the games.owner_id foreign key created
+// with ON DELETE CASCADE, which deleted a developer's games, and with them
+// every player's achievements and logins, along with their account.
+func restrictGameOwnerDeletion(tx *gorm.DB) error {
+	var deleteAction string
+	err := tx.Raw("SELECT confdeltype::text FROM pg_constraint WHERE conrelid = 'games'::regclass AND conname = 'fk_games_owner'").Scan(&deleteAction).Error
+	if err != nil || deleteAction != "c" {
+		return err
+	}
+	return tx.Exec(`ALTER TABLE games
+		DROP CONSTRAINT fk_games_owner,
+		ADD CONSTRAINT fk_games_owner FOREIGN KEY (owner_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE RESTRICT`).Error
+}
+
+const (
+	legacyAchievementsTable = "legacy_achievements"
+	// legacyGameSlug is the game that existed implicitly before games could
+	// be registered. Achievements from that time belong to it.
+	legacyGameSlug = "example"
+)
+
+// legacyAchievementDefinitions describes the achievements that used to be
+// hardcoded, by the name they were stored under.
+var legacyAchievementDefinitions = map[string]AchievementDefinition{
+	"first_login": {
+		Name:        "First Login",
+		Description: "Log in to a game for the first time",
+		IconURL:     "/public/img/first.png",
+		Points:      10,
+	},
+}
+
+// setAsideLegacyAchievements renames the achievements table while it still
+// refers to achievements by name, so AutoMigrate creates the current one
+// from scratch and migrateLegacyAchievements can move the rows over.
+func setAsideLegacyAchievements(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&Achievement{}) || !tx.Migrator().HasColumn(&Achievement{}, "name") {
+		return nil
+	}
+	return tx.Migrator().RenameTable(&Achievement{}, legacyAchievementsTable)
+}
+
+// removeDuplicateAchievements keeps only the first unlock of each achievement
+// per user, so the unique index on them can be created.
+func removeDuplicateAchievements(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&Achievement{}) || tx.Migrator().HasIndex(&Achievement{}, "idx_achievements_user_definition") {
+		return nil
+	}
+	return tx.Exec(`DELETE FROM achievements a USING achievements b
+		WHERE a.user_id = b.user_id AND a.definition_id = b.definition_id AND (a.created_at, a.id) > (b.created_at, b.id)`).Error
+}
+
+// migrateLegacyAchievements resolves the names of set aside achievements to
+// definitions of the legacy game, creating those that don't exist, and moves
+// them into the achievements table.
+func migrateLegacyAchievements(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(legacyAchievementsTable) {
+		return nil
+	}
+	if !tx.Migrator().HasColumn(legacyAchievementsTable, "definition_id") {
+		if err := tx.Exec("ALTER TABLE " + legacyAchievementsTable + " ADD COLUMN definition_id text").Error; err != nil {
+			return err
+		}
+	}
+	var names []string
+	err := tx.Table(legacyAchievementsTable).Distinct("name").Where("definition_id IS NULL").Pluck("name", &names).Error
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		game, err := legacyGame(tx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			definition, err := legacyDefinition(tx, game.ID, name)
+			if err != nil {
+				return err
+			}
+			err = tx.Table(legacyAchievementsTable).Where("definition_id IS NULL AND name = ?", name).Update("definition_id", definition.ID).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = tx.Exec(`INSERT INTO achievements (id, user_id, definition_id, created_at)
+		SELECT id, user_id, definition_id, created_at FROM ` + legacyAchievementsTable + `
+		ON CONFLICT DO NOTHING`).Error
+	if err != nil {
+		return err
+	}
+	return tx.Migrator().DropTable(legacyAchievementsTable)
+}
+
+// legacyGame returns the legacy game, registering it to the oldest account if
+// it doesn't exist. It is created without a usable client secret; the owner
+// issues one in the developer portal.
+func legacyGame(tx *gorm.DB) (*Game, error) {
+	var games []*Game
+	if err := tx.Where("slug = ?", legacyGameSlug).Limit(1).Find(&games).Error; err != nil {
+		return nil, err
+	}
+	if len(games) > 0 {
+		return games[0], nil
+	}
+	ownerID, err := oldestUserID(tx)
+	if err != nil {
+		return nil, err
+	}
+	game := &Game{
+		ID:        ulid.Make().String(),
+		OwnerID:   ownerID,
+		Slug:      legacyGameSlug,
+		Name:      "Example Game",
+		ClientID:  ulid.Make().String(),
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(game).Error; err != nil {
+		return nil, err
+	}
+	return game, nil
+}
+
+func legacyDefinition(tx *gorm.DB, gameID string, key string) (*AchievementDefinition, error) {
+	var definitions []*AchievementDefinition
+	if err := tx.Where("game_id = ? AND key = ?", gameID, key).Limit(1).Find(&definitions).Error; err != nil {
+		return nil, err
+	}
+	if len(definitions) > 0 {
+		return definitions[0], nil
+	}
+	definition := legacyAchievementDefinitions[key]
+	if definition.Name == "" {
+		definition.Name = key
+	}
+	definition.ID = ulid.Make().String()
+	definition.GameID = gameID
+	definition.Key = key
+	if err := tx.Create(&definition).Error; err != nil {
+		return nil, err
+	}
+	return &definition, nil
+}
+
+var errNoUsers = errors.New("there are no accounts")
+
+// oldestUserID returns the ID of the first account created. IDs are ULIDs,
+// so they sort by creation time.
+func oldestUserID(tx *gorm.DB) (string, error) {
+	var ids []string
+	if err := tx.Model(&User{}).Order("id").Limit(1).Pluck("id", &ids).Error; err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", errNoUsers
+	}
+	return ids[0], nil
+}
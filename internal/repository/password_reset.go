@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// PasswordReset is a single-use token mailed to a user so they can choose a
+// new password. Only the hash of the token is stored.
+type PasswordReset struct {
+	ID        string     `gorm:"primaryKey"`
+	UserID    string     `gorm:"index;not null"`
+	Token     string     `gorm:"not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+	User      *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type PasswordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+type CreatePasswordResetRequest struct {
+	UserID    string
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (r *PasswordResetRepository) Create(ctx context.Context, req *CreatePasswordResetRequest) (*PasswordReset, error) {
+	reset := &PasswordReset{
+		ID:        ulid.Make().String(),
+		UserID:    req.UserID,
+		Token:     req.Token,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := r.db.WithContext(ctx).Create(reset).Error; err != nil {
+		return nil, err
+	}
+	return reset, nil
+}
+
+func (r *PasswordResetRepository) GetByID(ctx context.Context, id string) (*PasswordReset, error) {
+	var reset PasswordReset
+	err := r.db.WithContext(ctx).Preload("User").Where("id = ?", id).First(&reset).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &reset, nil
+}
+
+// MarkUsed atomically marks the reset as used. It reports false if it had
+// already been used.
+func (r *PasswordResetRepository) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkAllUsedByUserID invalidates every outstanding reset of the user.
+func (r *PasswordResetRepository) MarkAllUsedByUserID(ctx context.Context, userID string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&PasswordReset{}).Where("user_id = ? AND used_at IS NULL", userID).Update("used_at", at).Error
+}
+
+// DeleteExpired deletes up to limit resets that expired before the given
+// time.
+func (r *PasswordResetRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ids := r.db.Model(&PasswordReset{}).Select("id").Where("expires_at < ?", before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&PasswordReset{})
+	return result.RowsAffected, result.Error
+}
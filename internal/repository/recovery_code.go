@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-time code that can stand in for a TOTP code when the
+// user has lost their authenticator. Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        string     `gorm:"primaryKey"`
+	UserID    string     `gorm:"index;not null"`
+	Code      string     `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+	User      *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type RecoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{db: db}
+}
+
+// Replace deletes the user's recovery codes and stores the given hashed codes
+// in their place.
+func (r *RecoveryCodeRepository) Replace(ctx context.Context, userID string, hashedCodes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		codes := make([]*RecoveryCode, len(hashedCodes))
+		for i, code := range hashedCodes {
+			codes[i] = &RecoveryCode{
+				ID:        ulid.Make().String(),
+				UserID:    userID,
+				Code:      code,
+				CreatedAt: now,
+			}
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(codes).Error
+	})
+}
+
+func (r *RecoveryCodeRepository) GetUnusedByUserID(ctx context.Context, userID string) ([]*RecoveryCode, error) {
+	var codes []*RecoveryCode
+	err := r.db.WithContext(ctx).Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (r *RecoveryCodeRepository) CountUnusedByUserID(ctx context.Context, userID string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkUsed atomically marks the code as used. It reports false if it had
+// already been used.
+func (r *RecoveryCodeRepository) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&RecoveryCode{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *RecoveryCodeRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
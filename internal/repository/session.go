@@ -10,10 +10,15 @@ import (
 )
 
 type Session struct {
-	ID        string    `gorm:"primaryKey"`
-	UserID    string    `gorm:"not null"`
-	UserAgent string    `gorm:"not null"`
-	CreatedAt time.Time `gorm:"not null"`
+	ID                string    `gorm:"primaryKey"`
+	UserID            string    `gorm:"index;not null"`
+	UserAgent         string    `gorm:"not null"`
+	IP                string    `gorm:"not null;default:''"`
+	RememberMe        bool      `gorm:"not null;default:false"`
+	CreatedAt         time.Time `gorm:"not null"`
+	LastSeenAt        time.Time `gorm:"not null"`
+	ExpiresAt         time.Time `gorm:"not null"`
+	AbsoluteExpiresAt time.Time `gorm:"not null"`
 
 	User *User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
@@ -26,12 +31,27 @@ func NewSessionRepository(db *gorm.DB) *SessionRepository {
 	return &SessionRepository{db: db}
 }
 
-func (r *SessionRepository) Create(ctx context.Context, userID, userAgent string) (*Session, error) {
+type CreateSessionRequest struct {
+	UserID            string
+	UserAgent         string
+	IP                string
+	RememberMe        bool
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+}
+
+func (r *SessionRepository) Create(ctx context.Context, req *CreateSessionRequest) (*Session, error) {
+	now := time.Now()
 	session := &Session{
-		ID:        ulid.Make().String(),
-		UserID:    userID,
-		UserAgent: userAgent,
-		CreatedAt: time.Now(),
+		ID:                ulid.Make().String(),
+		UserID:            req.UserID,
+		UserAgent:         req.UserAgent,
+		IP:                req.IP,
+		RememberMe:        req.RememberMe,
+		CreatedAt:         now,
+		LastSeenAt:        now,
+		ExpiresAt:         req.ExpiresAt,
+		AbsoluteExpiresAt: req.AbsoluteExpiresAt,
 	}
 	if err := r.db.WithContext(ctx).Create(session).Error; err != nil {
 		return nil, err
@@ -54,3 +74,56 @@ func (r *SessionRepository) GetByID(ctx context.Context, id string) (*Session, e
 func (r *SessionRepository) Delete(ctx context.Context, id string) error {
 	return r.db.WithContext(ctx).Where("id = ?", id).Delete(&Session{}).Error
 }
+
+func (r *SessionRepository) GetByUserID(ctx context.Context, userID string) ([]*Session, error) {
+	var sessions []*Session
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("last_seen_at DESC").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// GetActiveByUserID returns the user's sessions that have not expired at now,
+// i.e. the ones that still sign them in.
+func (r *SessionRepository) GetActiveByUserID(ctx context.Context, userID string, now time.Time) ([]*Session, error) {
+	var sessions []*Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at >= ? AND absolute_expires_at >= ?", userID, now, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *SessionRepository) Touch(ctx context.Context, session *Session) error {
+	return r.db.WithContext(ctx).Model(&Session{}).Where("id = ?", session.ID).Updates(map[string]any{
+		"last_seen_at": session.LastSeenAt,
+		"expires_at":   session.ExpiresAt,
+		"ip":           session.IP,
+	}).Error
+}
+
+func (r *SessionRepository) DeleteByUserID(ctx context.Context, userID string, id string) error {
+	return r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&Session{}).Error
+}
+
+// DeleteOthersByUserID deletes every session of the user except keepID.
+func (r *SessionRepository) DeleteOthersByUserID(ctx context.Context, userID string, keepID string) error {
+	return r.db.WithContext(ctx).Where("user_id = ? AND id <> ?", userID, keepID).Delete(&Session{}).Error
+}
+
+// DeleteAllByUserID deletes every session of the user.
+func (r *SessionRepository) DeleteAllByUserID(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+// DeleteExpired deletes up to limit sessions whose idle or absolute deadline
+// passed before the given time.
+func (r *SessionRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ids := r.db.Model(&Session{}).Select("id").Where("expires_at < ? OR absolute_expires_at < ?", before, before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UsernameReservation holds a username a user gave up, so nobody else can
+// claim it until ExpiresAt. Username is stored in lower case.
+type UsernameReservation struct {
+	Username  string    `gorm:"primaryKey"`
+	UserID    string    `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+	User      *User     `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type UsernameReservationRepository struct {
+	db *gorm.DB
+}
+
+func NewUsernameReservationRepository(db *gorm.DB) *UsernameReservationRepository {
+	return &UsernameReservationRepository{db: db}
+}
+
+// GetActive returns the reservation of username that is still in effect at
+// the given time, if any.
+func (r *UsernameReservationRepository) GetActive(ctx context.Context, username string, at time.Time) (*UsernameReservation, error) {
+	var reservation UsernameReservation
+	err := r.db.WithContext(ctx).Where("username = ? AND expires_at > ?", strings.ToLower(username), at).First(&reservation).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// DeleteExpired deletes up to limit reservations that expired before the
+// given time.
+func (r *UsernameReservationRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	usernames := r.db.Model(&UsernameReservation{}).Select("username").Where("expires_at < ?", before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("username IN (?)", usernames).Delete(&UsernameReservation{})
+	return result.RowsAffected, result.Error
+}
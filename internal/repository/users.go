@@ -3,16 +3,45 @@ package repository
 import (
 	"context"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProfileVisibility controls who can see a user's public profile.
+type ProfileVisibility string
+
+const (
+	ProfileVisibilityPublic  = ProfileVisibility("public")
+	ProfileVisibilityFriends = ProfileVisibility("friends")
+	ProfileVisibilityPrivate = ProfileVisibility("private")
 )
 
 type User struct {
-	ID       string `gorm:"primaryKey"`
-	Username string `gorm:"unique;not null"`
-	Email    string `gorm:"not null"`
-	Password string `gorm:"not null"`
+	ID            string `gorm:"primaryKey"`
+	Username      string `gorm:"not null;uniqueIndex:idx_users_username_lower,expression:lower(username)"`
+	Email         string `gorm:"not null;uniqueIndex:idx_users_email_lower,expression:lower(email)"`
+	EmailVerified bool   `gorm:"not null;default:false"`
+	Password      string `gorm:"not null"`
+	// TOTPSecret is set as soon as enrollment starts; TOTPEnabled only once
+	// the user has confirmed a code. TOTPLastStep is the last time step a
+	// code was accepted for, so codes can't be replayed.
+	TOTPSecret   string `gorm:"not null;default:''"`
+	TOTPEnabled  bool   `gorm:"not null;default:false"`
+	TOTPLastStep int64  `gorm:"not null;default:0"`
+	// UsernameChangedAt is when the user last renamed themselves, used to
+	// enforce the cooldown between changes.
+	UsernameChangedAt *time.Time `gorm:"default:null"`
+	// DeletionScheduledAt is when the account will be deleted, if the user
+	// asked for that. Until then they can still sign in and cancel.
+	DeletionScheduledAt *time.Time        `gorm:"index;default:null"`
+	ProfileVisibility   ProfileVisibility `gorm:"not null;default:public"`
+	// AvatarID names the current set of avatar images after the hash of the
+	// uploaded image, or is empty if the user has none.
+	AvatarID string `gorm:"not null;default:''"`
 }
 
 type UserRepository struct {
@@ -31,10 +60,11 @@ type CreateUserRequest struct {
 
 func (r *UserRepository) Create(ctx context.Context, req *CreateUserRequest) (*User, error) {
 	user := &User{
-		ID:       ulid.Make().String(),
-		Username: req.Username,
-		Email:    req.Email,
-		Password: req.Password,
+		ID:                ulid.Make().String(),
+		Username:          req.Username,
+		Email:             req.Email,
+		Password:          req.Password,
+		ProfileVisibility: ProfileVisibilityPublic,
 	}
 	if err := r.db.WithContext(ctx).Create(user).Error; err != nil {
 		return nil, err
@@ -44,7 +74,19 @@ func (r *UserRepository) Create(ctx context.Context, req *CreateUserRequest) (*U
 
 func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*User, error) {
 	var user User
-	err := r.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
+	err := r.db.WithContext(ctx).Where("LOWER(username) = LOWER(?)", username).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	var user User
+	err := r.db.WithContext(ctx).Where("LOWER(email) = LOWER(?)", email).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -65,3 +107,148 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (*User, error)
 	}
 	return &user, nil
 }
+
+// MarkEmailVerified flags the user's email as verified, provided it is still
+// the given address.
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id string, email string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&User{}).Where("id = ? AND email = ?", id, email).Update("email_verified", true)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, id string, password string) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Update("password", password).Error
+}
+
+// ChangeUsername renames the user and reserves the old name for them until
+// reservedUntil. Any reservation the user held on the new name is released.
+func (r *UserRepository) ChangeUsername(ctx context.Context, id string, oldUsername string, newUsername string, at time.Time, reservedUntil time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", id).Updates(map[string]any{
+			"username":            newUsername,
+			"username_changed_at": at,
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("username = ? AND user_id = ?", strings.ToLower(newUsername), id).Delete(&UsernameReservation{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "username"}},
+			DoUpdates: clause.AssignmentColumns([]string{"user_id", "expires_at", "created_at"}),
+		}).Create(&UsernameReservation{
+			Username:  strings.ToLower(oldUsername),
+			UserID:    id,
+			ExpiresAt: reservedUntil,
+			CreatedAt: at,
+		}).Error
+	})
+}
+
+// ChangeEmail replaces the user's email with an address they have just
+// proven they own.
+func (r *UserRepository) ChangeEmail(ctx context.Context, id string, email string) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Updates(map[string]any{
+		"email":          email,
+		"email_verified": true,
+	}).Error
+}
+
+func (r *UserRepository) UpdateProfileVisibility(ctx context.Context, id string, visibility ProfileVisibility) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Update("profile_visibility", visibility).Error
+}
+
+func (r *UserRepository) UpdateAvatar(ctx context.Context, id string, avatarID string) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Update("avatar_id", avatarID).Error
+}
+
+func (r *UserRepository) ScheduleDeletion(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Update("deletion_scheduled_at", at).Error
+}
+
+func (r *UserRepository) CancelDeletion(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Update("deletion_scheduled_at", nil).Error
+}
+
+// GetDueForDeletion returns up to limit users whose deletion is scheduled at
+// or before the given time.
+func (r *UserRepository) GetDueForDeletion(ctx context.Context, at time.Time, limit int) ([]*User, error) {
+	var users []*User
+	err := r.db.WithContext(ctx).Where("deletion_scheduled_at <= ?", at).Order("deletion_scheduled_at").Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+var errDeletionCancelled = errors.New("deletion cancelled")
+
+// DeleteScheduled deletes the user, provided their deletion is still
+// scheduled at or before the given time, and queues the given webhook
+// deliveries in the same transaction. Everything owned by the user goes with
+// it through the cascading foreign keys. It reports false if the deletion
+// was cancelled in the meantime.
+func (r *UserRepository) DeleteScheduled(ctx context.Context, id string, at time.Time, deliveries []*CreateWebhookDeliveryRequest) (bool, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, req := range deliveries {
+			if err := tx.Create(newWebhookDelivery(req)).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Where("id = ? AND deletion_scheduled_at <= ?", id, at).Delete(&User{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Roll back the deliveries too.
+			return errDeletionCancelled
+		}
+		return nil
+	})
+	if errors.Is(err, errDeletionCancelled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetTOTPSecret starts TOTP enrollment with a new secret, leaving TOTP
+// disabled until EnableTOTP is called.
+func (r *UserRepository) SetTOTPSecret(ctx context.Context, id string, secret string) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Updates(map[string]any{
+		"totp_secret":    secret,
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+}
+
+func (r *UserRepository) EnableTOTP(ctx context.Context, id string, step int64) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Updates(map[string]any{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error
+}
+
+func (r *UserRepository) DisableTOTP(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Updates(map[string]any{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+}
+
+// AdvanceTOTPStep atomically records step as used. It reports false if a
+// code for the same or a later step was already accepted.
+func (r *UserRepository) AdvanceTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&User{}).Where("id = ? AND totp_last_step < ?", id, step).Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// WebhookDelivery is an event waiting to be posted to a game's webhook URL.
+// Deliveries are retried until they succeed or are given up on.
+type WebhookDelivery struct {
+	ID            string     `gorm:"primaryKey"`
+	GameID        string     `gorm:"index;not null"`
+	Event         string     `gorm:"not null"`
+	Payload       string     `gorm:"not null"`
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"index;not null"`
+	CreatedAt     time.Time  `gorm:"not null"`
+	DeliveredAt   *time.Time `gorm:"default:null"`
+	FailedAt      *time.Time `gorm:"default:null"`
+	Game          *Game      `gorm:"foreignKey:GameID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+type WebhookDeliveryRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookDeliveryRepository(db *gorm.DB) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{db: db}
+}
+
+type CreateWebhookDeliveryRequest struct {
+	GameID  string
+	Event   string
+	Payload string
+}
+
+func newWebhookDelivery(req *CreateWebhookDeliveryRequest) *WebhookDelivery {
+	now := time.Now()
+	return &WebhookDelivery{
+		ID:            ulid.Make().String(),
+		GameID:        req.GameID,
+		Event:         req.Event,
+		Payload:       req.Payload,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// GetDue returns up to limit deliveries that are pending and due at the
+// given time, oldest first.
+func (r *WebhookDeliveryRepository) GetDue(ctx context.Context, at time.Time, limit int) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	err := r.db.WithContext(ctx).Preload("Game").
+		Where("delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", at).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookDeliveryRepository) MarkDelivered(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&WebhookDelivery{}).Where("id = ?", id).Updates(map[string]any{
+		"attempts":     gorm.Expr("attempts + 1"),
+		"delivered_at": at,
+	}).Error
+}
+
+// MarkAttemptFailed records a failed attempt and schedules the next one.
+func (r *WebhookDeliveryRepository) MarkAttemptFailed(ctx context.Context, id string, next time.Time) error {
+	return r.db.WithContext(ctx).Model(&WebhookDelivery{}).Where("id = ?", id).Updates(map[string]any{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"next_attempt_at": next,
+	}).Error
+}
+
+// MarkFailed records a failed attempt and gives up on the delivery.
+func (r *WebhookDeliveryRepository) MarkFailed(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&WebhookDelivery{}).Where("id = ?", id).Updates(map[string]any{
+		"attempts":  gorm.Expr("attempts + 1"),
+		"failed_at": at,
+	}).Error
+}
+
+// DeleteFinished deletes up to limit deliveries that were delivered or given
+// up on before the given time.
+func (r *WebhookDeliveryRepository) DeleteFinished(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ids := r.db.Model(&WebhookDelivery{}).Select("id").Where("delivered_at < ? OR failed_at < ?", before, before).Limit(limit)
+	result := r.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&WebhookDelivery{})
+	return result.RowsAffected, result.Error
+}
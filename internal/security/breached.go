@@ -0,0 +1,28 @@
+package security
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// breachedPasswordList is a list of the most common passwords found in public
+// breach corpora, one per line in lower case.
+//
+//go:embed breached_passwords.txt
+var breachedPasswordList string
+
+var breachedPasswords = func() map[string]bool {
+	set := make(map[string]bool)
+	for _, line := range strings.Split(breachedPasswordList, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			set[line] = true
+		}
+	}
+	return set
+}()
+
+// IsBreachedPassword reports whether password, ignoring case, is on the
+// bundled list of common breached passwords.
+func IsBreachedPassword(password string) bool {
+	return breachedPasswords[strings.ToLower(password)]
+}
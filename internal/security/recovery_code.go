@@ -0,0 +1,54 @@
+package security
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// recoveryCodeCharset leaves out characters that are easy to misread when a
+// code is copied from paper: 0/o, 1/l/i.
+const recoveryCodeCharset = "abcdefghjkmnpqrstuvwxyz23456789"
+
+const recoveryCodeLength = 10
+
+// GenerateRecoveryCode returns a one-time code such as "k7dqm-2hxwp".
+func GenerateRecoveryCode() string {
+	b := make([]byte, recoveryCodeLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic("failed to generate recovery code: " + err.Error())
+	}
+	code := make([]byte, 0, recoveryCodeLength+1)
+	for i := range b {
+		if i == recoveryCodeLength/2 {
+			code = append(code, '-')
+		}
+		code = append(code, recoveryCodeCharset[int(b[i])%len(recoveryCodeCharset)])
+	}
+	return string(code)
+}
+
+// NormalizeRecoveryCode converts user input such as "K7DQM 2HXWP" into the
+// canonical "k7dqm-2hxwp" form.
+func NormalizeRecoveryCode(input string) (string, bool) {
+	code := make([]byte, 0, recoveryCodeLength+1)
+	for _, c := range strings.ToLower(input) {
+		if c == '-' || c == ' ' {
+			continue
+		}
+		if !strings.ContainsRune(recoveryCodeCharset, c) {
+			return "", false
+		}
+		if len(code) == recoveryCodeLength/2 {
+			code = append(code, '-')
+		}
+		code = append(code, byte(c))
+		if len(code) > recoveryCodeLength+1 {
+			return "", false
+		}
+	}
+	if len(code) != recoveryCodeLength+1 {
+		return "", false
+	}
+	return string(code), true
+}
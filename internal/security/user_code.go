@@ -0,0 +1,57 @@
+package security
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// userCodeCharset follows RFC 8628 section 6.1: consonants only, so codes
+// are easy to type and cannot spell words or be confused with digits.
+const userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+const userCodeLength = 8
+
+// GenerateUserCode returns a short human-typable code such as "WDJB-MJHT".
+// Every character is drawn uniformly, so no code is easier to guess than
+// another.
+func GenerateUserCode() string {
+	charsetSize := big.NewInt(int64(len(userCodeCharset)))
+	code := make([]byte, 0, userCodeLength+1)
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, charsetSize)
+		if err != nil {
+			panic("failed to generate user code: " + err.Error())
+		}
+		code = append(code, userCodeCharset[n.Int64()])
+	}
+	return string(code)
+}
+
+// NormalizeUserCode converts user input such as "wdjb mjht" into the canonical
+// "WDJB-MJHT" form. Separators and case are ignored.
+func NormalizeUserCode(input string) (string, bool) {
+	code := make([]byte, 0, userCodeLength+1)
+	for _, c := range strings.ToUpper(input) {
+		if c == '-' || c == ' ' {
+			continue
+		}
+		if !strings.ContainsRune(userCodeCharset, c) {
+			return "", false
+		}
+		if len(code) == userCodeLength/2 {
+			code = append(code, '-')
+		}
+		code = append(code, byte(c))
+		if len(code) > userCodeLength+1 {
+			return "", false
+		}
+	}
+	if len(code) != userCodeLength+1 {
+		return "", false
+	}
+	return string(code), true
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/repository"
+	"gt/internal/security"
+	"log"
+	"time"
+)
+
+var (
+	ErrDeletionAlreadyScheduled = errors.New("account deletion is already scheduled")
+	ErrDeletionNotScheduled     = errors.New("account deletion is not scheduled")
+	ErrOwnsGames                = errors.New("you can't delete your account while you own games in the developer portal")
+)
+
+// AccountService handles deleting accounts and exporting their data.
+type AccountService struct {
+	userRepo        *repository.UserRepository
+	sessionRepo     *repository.SessionRepository
+	gameRepo        *repository.GameRepository
+	gameLoginRepo   *repository.GameLoginRepository
+	achievementRepo *repository.AchievementRepository
+	friendshipRepo  *repository.FriendshipRepository
+	avatarService   *AvatarService
+	// deletionGracePeriod is how long a user has to change their mind after
+	// asking for their account to be deleted.
+	deletionGracePeriod time.Duration
+}
+
+func NewAccountService(
+	userRepo *repository.UserRepository,
+	sessionRepo *repository.SessionRepository,
+	gameRepo *repository.GameRepository,
+	gameLoginRepo *repository.GameLoginRepository,
+	achievementRepo *repository.AchievementRepository,
+	friendshipRepo *repository.FriendshipRepository,
+	avatarService *AvatarService,
+	deletionGracePeriod time.Duration,
+) *AccountService {
+	return &AccountService{
+		userRepo:            userRepo,
+		sessionRepo:         sessionRepo,
+		gameRepo:            gameRepo,
+		gameLoginRepo:       gameLoginRepo,
+		achievementRepo:     achievementRepo,
+		friendshipRepo:      friendshipRepo,
+		avatarService:       avatarService,
+		deletionGracePeriod: deletionGracePeriod,
+	}
+}
+
+func (s *AccountService) DeletionGracePeriod() time.Duration {
+	return s.deletionGracePeriod
+}
+
+// ScheduleDeletion asks for the password again and schedules the account to
+// be deleted once the grace period is over. Every session but the current
+// one is signed out; the user can sign in again to cancel.
+func (s *AccountService) ScheduleDeletion(ctx context.Context, user *repository.User, sessionID string, password string) error {
+	if user.DeletionScheduledAt != nil {
+		return ErrDeletionAlreadyScheduled
+	}
+	if !security.CheckPasswordHash(password, user.Password) {
+		return ErrInvalidCredentials
+	}
+	ownsGames, err := s.ownsGames(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if ownsGames {
+		return ErrOwnsGames
+	}
+	at := time.Now().Add(s.deletionGracePeriod)
+	if err := s.userRepo.ScheduleDeletion(ctx, user.ID, at); err != nil {
+		return err
+	}
+	user.DeletionScheduledAt = &at
+	return s.sessionRepo.DeleteOthersByUserID(ctx, user.ID, sessionID)
+}
+
+func (s *AccountService) ownsGames(ctx context.Context, userID string) (bool, error) {
+	games, err := s.gameRepo.GetByOwnerID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return len(games) > 0, nil
+}
+
+func (s *AccountService) CancelDeletion(ctx context.Context, user *repository.User) error {
+	if user.DeletionScheduledAt == nil {
+		return ErrDeletionNotScheduled
+	}
+	if err := s.userRepo.CancelDeletion(ctx, user.ID); err != nil {
+		return err
+	}
+	user.DeletionScheduledAt = nil
+	return nil
+}
+
+// DeleteDue deletes up to limit accounts whose grace period is over and
+// queues a WebhookEventUserDeleted delivery for every game they were linked
+// to. It returns how many accounts were deleted.
+func (s *AccountService) DeleteDue(ctx context.Context, limit int) (int64, error) {
+	now := time.Now()
+	users, err := s.userRepo.GetDueForDeletion(ctx, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, user := range users {
+		// Games may have been registered during the grace period. Deleting
+		// the owner is refused by the database, so the deletion is called
+		// off instead of blocking the queue.
+		ownsGames, err := s.ownsGames(ctx, user.ID)
+		if err != nil {
+			return deleted, err
+		}
+		if ownsGames {
+			log.Printf("cancelled deletion of user %s: they own games", user.ID)
+			if err := s.userRepo.CancelDeletion(ctx, user.ID); err != nil {
+				return deleted, err
+			}
+			continue
+		}
+		games, err := s.gameRepo.GetWithWebhookByLinkedUserID(ctx, user.ID)
+		if err != nil {
+			return deleted, err
+		}
+		deliveries, err := userDeletedDeliveries(games, user.ID, now)
+		if err != nil {
+			return deleted, err
+		}
+		ok, err := s.userRepo.DeleteScheduled(ctx, user.ID, now, deliveries)
+		if err != nil {
+			return deleted, err
+		}
+		if ok {
+			deleted++
+			s.avatarService.deleteFiles(ctx, user.ID, user.AvatarID)
+		}
+	}
+	return deleted, nil
+}
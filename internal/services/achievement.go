@@ -8,31 +8,140 @@ import (
 
 type AchievementService struct {
 	achievementRepo *repository.AchievementRepository
+	definitionRepo  *repository.AchievementDefinitionRepository
+	progressRepo    *repository.AchievementProgressRepository
 }
 
 func (s *AchievementService) GetAchievementsByUserID(ctx context.Context, userID string) ([]*repository.Achievement, error) {
 	return s.achievementRepo.GetByUserID(ctx, userID)
 }
 
-func NewAchievementService(achievementRepo *repository.AchievementRepository) *AchievementService {
-	return &AchievementService{achievementRepo: achievementRepo}
+// GetProgressByUserID returns the user's progress on achievements they have
+// not unlocked yet. Hidden and retired achievements are left out.
+func (s *AchievementService) GetProgressByUserID(ctx context.Context, userID string) ([]*repository.AchievementProgress, error) {
+	all, err := s.progressRepo.GetInProgressByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	progress := make([]*repository.AchievementProgress, 0, len(all))
+	for _, p := range all {
+		if p.Definition.Target > 0 && !p.Definition.Hidden && !p.Definition.Retired {
+			progress = append(progress, p)
+		}
+	}
+	return progress, nil
+}
+
+func NewAchievementService(achievementRepo *repository.AchievementRepository, definitionRepo *repository.AchievementDefinitionRepository, progressRepo *repository.AchievementProgressRepository) *AchievementService {
+	return &AchievementService{achievementRepo: achievementRepo, definitionRepo: definitionRepo, progressRepo: progressRepo}
 }
 
 var (
 	ErrAchievementAlreadyExists = errors.New("achievement already exists for user")
+	ErrAchievementNotProgress   = errors.New("achievement does not track progress")
+	ErrAchievementProgressOnly  = errors.New("achievement unlocks by reporting progress")
+	ErrInvalidProgressValue     = errors.New("invalid progress value")
 )
 
-func (s *AchievementService) CreateAchievement(ctx context.Context, req *repository.CreateAchievementRequest) (*repository.Achievement, error) {
-	contains, err := s.achievementRepo.Contains(ctx, req.UserID, req.Name)
+type CreateAchievementRequest struct {
+	UserID string
+	GameID string
+	Key    string
+}
+
+func (s *AchievementService) CreateAchievement(ctx context.Context, req *CreateAchievementRequest) (*repository.Achievement, error) {
+	definition, err := s.definitionRepo.GetByKey(ctx, req.GameID, req.Key)
 	if err != nil {
 		return nil, err
 	}
-	if contains {
-		return nil, ErrAchievementAlreadyExists
+	if definition == nil {
+		return nil, ErrAchievementDefinitionNotFound
 	}
-	achievement, err := s.achievementRepo.Create(ctx, req)
+	if definition.Retired {
+		return nil, ErrAchievementDefinitionRetired
+	}
+	if definition.Target > 0 {
+		return nil, ErrAchievementProgressOnly
+	}
+	achievement, err := s.achievementRepo.Create(ctx, &repository.CreateAchievementRequest{
+		UserID:       req.UserID,
+		DefinitionID: definition.ID,
+	})
 	if err != nil {
 		return nil, err
 	}
+	if achievement == nil {
+		return nil, ErrAchievementAlreadyExists
+	}
+	achievement.Definition = definition
 	return achievement, nil
 }
+
+type ReportProgressRequest struct {
+	UserID string
+	GameID string
+	Key    string
+	// Value replaces the stored progress, or is added to it if Increment is
+	// set.
+	Value     int
+	Increment bool
+}
+
+// ProgressReport is the state of a progress achievement after a report.
+type ProgressReport struct {
+	Definition *repository.AchievementDefinition
+	Value      int
+	Unlocked   bool
+	// Achievement is set if this report unlocked the achievement.
+	Achievement *repository.Achievement
+}
+
+// ReportProgress records the user's progress on a progress achievement and
+// unlocks it once the target is reached. Reports for an achievement that is
+// already unlocked are accepted and change nothing, so games don't have to
+// track which achievements they have finished.
+func (s *AchievementService) ReportProgress(ctx context.Context, req *ReportProgressRequest) (*ProgressReport, error) {
+	if req.Value < 0 || req.Increment && req.Value == 0 {
+		return nil, ErrInvalidProgressValue
+	}
+	definition, err := s.definitionRepo.GetByKey(ctx, req.GameID, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	if definition == nil {
+		return nil, ErrAchievementDefinitionNotFound
+	}
+	if definition.Retired {
+		return nil, ErrAchievementDefinitionRetired
+	}
+	if definition.Target == 0 {
+		return nil, ErrAchievementNotProgress
+	}
+	contains, err := s.achievementRepo.Contains(ctx, req.UserID, definition.ID)
+	if err != nil {
+		return nil, err
+	}
+	if contains {
+		return &ProgressReport{Definition: definition, Value: definition.Target, Unlocked: true}, nil
+	}
+	progress, achievement, err := s.progressRepo.Record(ctx, &repository.RecordAchievementProgressRequest{
+		UserID:       req.UserID,
+		DefinitionID: definition.ID,
+		Value:        req.Value,
+		Increment:    req.Increment,
+		Target:       definition.Target,
+	})
+	if err != nil {
+		return nil, err
+	}
+	report := &ProgressReport{
+		Definition: definition,
+		Value:      min(progress.Value, definition.Target),
+		Unlocked:   progress.Value >= definition.Target,
+	}
+	if achievement != nil {
+		achievement.Definition = definition
+		report.Achievement = achievement
+	}
+	return report, nil
+}
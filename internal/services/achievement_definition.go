@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/repository"
+	"regexp"
+	"strings"
+)
+
+type AchievementDefinitionService struct {
+	definitionRepo *repository.AchievementDefinitionRepository
+}
+
+func NewAchievementDefinitionService(definitionRepo *repository.AchievementDefinitionRepository) *AchievementDefinitionService {
+	return &AchievementDefinitionService{definitionRepo: definitionRepo}
+}
+
+var (
+	ErrAchievementDefinitionNotFound = errors.New("achievement definition not found")
+	ErrAchievementDefinitionExists   = errors.New("achievement with this key already exists")
+	ErrAchievementDefinitionRetired  = errors.New("achievement definition is retired")
+	ErrInvalidAchievementKey         = errors.New("key must be 1-64 characters of lowercase letters, digits and underscores")
+	ErrInvalidAchievementName        = errors.New("achievement name is required")
+	ErrInvalidAchievementPoints      = errors.New("points must not be negative")
+	ErrInvalidAchievementTarget      = errors.New("target must not be negative")
+)
+
+var achievementKeyPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+func validateDefinition(key, name string, points int, target int) error {
+	if !achievementKeyPattern.MatchString(key) {
+		return ErrInvalidAchievementKey
+	}
+	if strings.TrimSpace(name) == "" {
+		return ErrInvalidAchievementName
+	}
+	if points < 0 {
+		return ErrInvalidAchievementPoints
+	}
+	if target < 0 {
+		return ErrInvalidAchievementTarget
+	}
+	return nil
+}
+
+func (s *AchievementDefinitionService) GetDefinitionByID(ctx context.Context, gameID string, id string) (*repository.AchievementDefinition, error) {
+	definition, err := s.definitionRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if definition == nil || definition.GameID != gameID {
+		return nil, ErrAchievementDefinitionNotFound
+	}
+	return definition, nil
+}
+
+func (s *AchievementDefinitionService) GetDefinitionsByGameID(ctx context.Context, gameID string) ([]*repository.AchievementDefinition, error) {
+	return s.definitionRepo.GetByGameID(ctx, gameID)
+}
+
+func (s *AchievementDefinitionService) CreateDefinition(ctx context.Context, req *repository.CreateAchievementDefinitionRequest) (*repository.AchievementDefinition, error) {
+	if err := validateDefinition(req.Key, req.Name, req.Points, req.Target); err != nil {
+		return nil, err
+	}
+	existing, err := s.definitionRepo.GetByKey(ctx, req.GameID, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrAchievementDefinitionExists
+	}
+	return s.definitionRepo.Create(ctx, req)
+}
+
+// UpdateDefinition saves changes to an existing definition. The key is
+// immutable because games and unlocks refer to it. A changed target applies
+// from the next progress report on; existing unlocks are kept.
+func (s *AchievementDefinitionService) UpdateDefinition(ctx context.Context, definition *repository.AchievementDefinition) error {
+	if err := validateDefinition(definition.Key, definition.Name, definition.Points, definition.Target); err != nil {
+		return err
+	}
+	return s.definitionRepo.Update(ctx, definition)
+}
+
+// SetDefinitionRetired retires or restores a definition. Retired achievements
+// can no longer be unlocked but existing unlocks are kept.
+func (s *AchievementDefinitionService) SetDefinitionRetired(ctx context.Context, definition *repository.AchievementDefinition, retired bool) error {
+	definition.Retired = retired
+	return s.definitionRepo.Update(ctx, definition)
+}
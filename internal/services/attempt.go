@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/repository"
+	"time"
+)
+
+var ErrTooManyAttempts = errors.New("too many attempts, try again later")
+
+// AttemptLimiter allows at most limit failed attempts per key within window.
+// Attempts are stored in the database so the limit holds across replicas.
+type AttemptLimiter struct {
+	attemptRepo *repository.AttemptRepository
+	scope       string
+	limit       int64
+	window      time.Duration
+}
+
+func NewAttemptLimiter(attemptRepo *repository.AttemptRepository, scope string, limit int64, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{attemptRepo: attemptRepo, scope: scope, limit: limit, window: window}
+}
+
+func (l *AttemptLimiter) key(key string) string {
+	return l.scope + ":" + key
+}
+
+// Check returns ErrTooManyAttempts if any of the keys is over the limit.
+func (l *AttemptLimiter) Check(ctx context.Context, keys ...string) error {
+	since := time.Now().Add(-l.window)
+	for _, key := range keys {
+		count, err := l.attemptRepo.CountSince(ctx, l.key(key), since)
+		if err != nil {
+			return err
+		}
+		if count >= l.limit {
+			return ErrTooManyAttempts
+		}
+	}
+	return nil
+}
+
+func (l *AttemptLimiter) RecordFailure(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if _, err := l.attemptRepo.Create(ctx, l.key(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockedOutError is returned by AttemptBackoff while a key is locked out. It
+// matches ErrTooManyAttempts with errors.Is.
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return ErrTooManyAttempts.Error()
+}
+
+func (e *LockedOutError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// AttemptBackoff allows free failed attempts per key within window. After
+// that each failure locks the key out, starting at base and doubling with
+// every further failure up to max.
+type AttemptBackoff struct {
+	attemptRepo *repository.AttemptRepository
+	scope       string
+	free        int64
+	base        time.Duration
+	max         time.Duration
+	window      time.Duration
+}
+
+func NewAttemptBackoff(attemptRepo *repository.AttemptRepository, scope string, free int64, base, max, window time.Duration) *AttemptBackoff {
+	return &AttemptBackoff{attemptRepo: attemptRepo, scope: scope, free: free, base: base, max: max, window: window}
+}
+
+func (b *AttemptBackoff) key(key string) string {
+	return b.scope + ":" + key
+}
+
+func (b *AttemptBackoff) lockout(failures int64) time.Duration {
+	d := b.base
+	for i := b.free; i < failures && d < b.max; i++ {
+		d *= 2
+	}
+	return min(d, b.max)
+}
+
+// Check returns a *LockedOutError if any of the keys is locked out, with the
+// longest remaining lockout.
+func (b *AttemptBackoff) Check(ctx context.Context, keys ...string) error {
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, key := range keys {
+		count, latest, err := b.attemptRepo.StatsSince(ctx, b.key(key), now.Add(-b.window))
+		if err != nil {
+			return err
+		}
+		if count < b.free {
+			continue
+		}
+		if remaining := latest.Add(b.lockout(count)).Sub(now); remaining > retryAfter {
+			retryAfter = remaining
+		}
+	}
+	if retryAfter > 0 {
+		return &LockedOutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (b *AttemptBackoff) RecordFailure(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if _, err := b.attemptRepo.Create(ctx, b.key(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset forgets the failed attempts of the keys.
+func (b *AttemptBackoff) Reset(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := b.attemptRepo.DeleteByKey(ctx, b.key(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,17 +3,68 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"gt/internal/repository"
 	"gt/internal/security"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type AuthService struct {
-	userRepo    *repository.UserRepository
-	sessionRepo *repository.SessionRepository
+	userRepo                *repository.UserRepository
+	sessionRepo             *repository.SessionRepository
+	usernameReservationRepo *repository.UsernameReservationRepository
+	sessionConfig           SessionConfig
+	passwordPolicy          PasswordPolicy
+	// Failed logins are throttled both per account name and per client IP.
+	accountBackoff *AttemptBackoff
+	ipBackoff      *AttemptBackoff
+	// signer signs the challenge that carries a login between the password
+	// and the second factor step.
+	signer           *security.Signer
+	twoFactorService *TwoFactorService
 }
 
-func NewAuthService(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository) *AuthService {
-	return &AuthService{userRepo: userRepo, sessionRepo: sessionRepo}
+// SessionConfig controls how long web sessions live. A session expires after
+// the idle timeout without activity, and after the absolute timeout no matter
+// what. "Remember me" sessions use the longer pair of timeouts.
+type SessionConfig struct {
+	IdleTimeout             time.Duration
+	AbsoluteTimeout         time.Duration
+	RememberIdleTimeout     time.Duration
+	RememberAbsoluteTimeout time.Duration
+}
+
+func (c SessionConfig) timeouts(rememberMe bool) (idle, absolute time.Duration) {
+	if rememberMe {
+		return c.RememberIdleTimeout, c.RememberAbsoluteTimeout
+	}
+	return c.IdleTimeout, c.AbsoluteTimeout
+}
+
+func NewAuthService(
+	userRepo *repository.UserRepository,
+	sessionRepo *repository.SessionRepository,
+	usernameReservationRepo *repository.UsernameReservationRepository,
+	sessionConfig SessionConfig,
+	passwordPolicy PasswordPolicy,
+	accountBackoff *AttemptBackoff,
+	ipBackoff *AttemptBackoff,
+	signer *security.Signer,
+	twoFactorService *TwoFactorService,
+) *AuthService {
+	return &AuthService{
+		userRepo:                userRepo,
+		sessionRepo:             sessionRepo,
+		usernameReservationRepo: usernameReservationRepo,
+		sessionConfig:           sessionConfig,
+		passwordPolicy:          passwordPolicy,
+		accountBackoff:          accountBackoff,
+		ipBackoff:               ipBackoff,
+		signer:                  signer,
+		twoFactorService:        twoFactorService,
+	}
 }
 
 type SignupRequest struct {
@@ -22,59 +73,283 @@ type SignupRequest struct {
 	Password string
 }
 
+// Signup form fields that a SignupError can refer to.
+const (
+	SignupFieldUsername = "username"
+	SignupFieldEmail    = "email"
+	SignupFieldPassword = "password"
+)
+
+// SignupError describes a problem with one field of a signup.
 type SignupError struct {
+	Field   string
 	Message string
 }
 
 func (e *SignupError) Error() string {
-	return e.Message
+	return e.Field + ": " + e.Message
+}
+
+// SignupErrors holds every problem found with a signup, so they can all be
+// shown at once.
+type SignupErrors []*SignupError
+
+func (e SignupErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
 }
 
+// Signup validates and normalizes the request and creates the user.
+// Validation problems are returned together as SignupErrors.
 func (s *AuthService) Signup(ctx context.Context, req SignupRequest) (*repository.User, error) {
-	existing, err := s.userRepo.GetByUsername(ctx, req.Username)
-	if err != nil {
-		return nil, err
+	username := NormalizeUsername(req.Username)
+	email := NormalizeEmail(req.Email)
+
+	var errs SignupErrors
+	if message := ValidateUsername(username); message != "" {
+		errs = append(errs, &SignupError{Field: SignupFieldUsername, Message: message})
+	} else {
+		taken, err := usernameTaken(ctx, s.userRepo, s.usernameReservationRepo, username, "")
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			errs = append(errs, &SignupError{Field: SignupFieldUsername, Message: "Username is already taken"})
+		}
 	}
-	if existing != nil {
-		return nil, &SignupError{Message: "Username already exists"}
+	if message := ValidateEmail(email); message != "" {
+		errs = append(errs, &SignupError{Field: SignupFieldEmail, Message: message})
+	} else {
+		existing, err := s.userRepo.GetByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			errs = append(errs, &SignupError{Field: SignupFieldEmail, Message: "An account with this email already exists"})
+		}
 	}
+	if message := s.passwordPolicy.Check(req.Password, username, email); message != "" {
+		errs = append(errs, &SignupError{Field: SignupFieldPassword, Message: message})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
 	hashed, err := security.HashPassword(req.Password)
 	if err != nil {
 		return nil, err
 	}
 	return s.userRepo.Create(ctx, &repository.CreateUserRequest{
-		Username: req.Username,
-		Email:    req.Email,
+		Username: username,
+		Email:    email,
 		Password: hashed,
 	})
 }
 
 type LoginRequest struct {
-	Username  string
-	Password  string
-	UserAgent string
+	Username   string
+	Password   string
+	UserAgent  string
+	IP         string
+	RememberMe bool
 }
 
 var ErrInvalidCredentials = errors.New("invalid username or password")
 
+// dummyPasswordHash is checked against when the username doesn't exist, so
+// that unknown and known usernames take the same time to reject.
+var dummyPasswordHash, _ = security.HashPassword("dummy password")
+
+// Login checks the credentials and starts a session. Failures are counted
+// against the account name whether or not it exists, so a lockout (reported
+// as a *LockedOutError) doesn't reveal which usernames are registered.
+// Accounts with two-factor authentication get a *SecondFactorRequiredError
+// instead of a session.
 func (s *AuthService) Login(ctx context.Context, req LoginRequest) (*repository.Session, error) {
+	account := strings.ToLower(strings.TrimSpace(req.Username))
+	if err := s.checkLoginBackoff(ctx, account, req.IP); err != nil {
+		return nil, err
+	}
 	user, err := s.userRepo.GetByUsername(ctx, req.Username)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, ErrInvalidCredentials
+		security.CheckPasswordHash(req.Password, dummyPasswordHash)
 	}
-	if !security.CheckPasswordHash(req.Password, user.Password) {
+	if user == nil || !security.CheckPasswordHash(req.Password, user.Password) {
+		if err := s.recordLoginFailure(ctx, account, req.IP); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
-	return s.sessionRepo.Create(ctx, user.ID, req.UserAgent)
+	if user.TOTPEnabled {
+		// The failure count is only reset once the second factor is checked
+		// too, so a known password can't be used to keep guessing codes.
+		return nil, &SecondFactorRequiredError{Challenge: s.loginChallenge(user.ID, req.RememberMe)}
+	}
+	if err := s.accountBackoff.Reset(ctx, account); err != nil {
+		return nil, err
+	}
+	return s.createSession(ctx, user.ID, req.UserAgent, req.IP, req.RememberMe)
+}
+
+// SecondFactorRequiredError is returned by Login when the password is right
+// but the account has two-factor authentication enabled. The challenge is
+// passed to LoginSecondFactor together with the code.
+type SecondFactorRequiredError struct {
+	Challenge string
 }
 
-func (s *AuthService) Authenticate(ctx context.Context, sessionID string) (*repository.Session, error) {
-	return s.sessionRepo.GetByID(ctx, sessionID)
+func (e *SecondFactorRequiredError) Error() string {
+	return "second factor required"
+}
+
+var ErrLoginChallengeExpired = errors.New("login attempt expired, please sign in again")
+
+const (
+	loginChallengePurpose = "login-second-factor"
+	loginChallengeTTL     = 5 * time.Minute
+)
+
+func (s *AuthService) loginChallenge(userID string, rememberMe bool) string {
+	expiresAt := time.Now().Add(loginChallengeTTL).Unix()
+	return s.signer.Sign(loginChallengePurpose, fmt.Sprintf("%s|%d|%t", userID, expiresAt, rememberMe))
+}
+
+func (s *AuthService) parseLoginChallenge(challenge string) (userID string, rememberMe bool, ok bool) {
+	value, ok := s.signer.Verify(loginChallengePurpose, challenge)
+	if !ok {
+		return "", false, false
+	}
+	parts := strings.Split(value, "|")
+	if len(parts) != 3 {
+		return "", false, false
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return "", false, false
+	}
+	return parts[0], parts[2] == "true", true
+}
+
+type LoginSecondFactorRequest struct {
+	Challenge string
+	Code      string
+	UserAgent string
+	IP        string
+}
+
+// LoginSecondFactor finishes a login started by Login with a TOTP code or a
+// recovery code.
+func (s *AuthService) LoginSecondFactor(ctx context.Context, req LoginSecondFactorRequest) (*repository.Session, error) {
+	userID, rememberMe, ok := s.parseLoginChallenge(req.Challenge)
+	if !ok {
+		return nil, ErrLoginChallengeExpired
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.TOTPEnabled {
+		return nil, ErrLoginChallengeExpired
+	}
+	account := strings.ToLower(user.Username)
+	if err := s.checkLoginBackoff(ctx, account, req.IP); err != nil {
+		return nil, err
+	}
+	err = s.twoFactorService.VerifySecondFactor(ctx, user, req.Code)
+	if errors.Is(err, ErrInvalidSecondFactor) {
+		if err := s.recordLoginFailure(ctx, account, req.IP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidSecondFactor
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accountBackoff.Reset(ctx, account); err != nil {
+		return nil, err
+	}
+	return s.createSession(ctx, user.ID, req.UserAgent, req.IP, rememberMe)
+}
+
+func (s *AuthService) checkLoginBackoff(ctx context.Context, account string, ip string) error {
+	if err := s.accountBackoff.Check(ctx, account); err != nil {
+		return err
+	}
+	return s.ipBackoff.Check(ctx, ip)
+}
+
+func (s *AuthService) recordLoginFailure(ctx context.Context, account string, ip string) error {
+	if err := s.accountBackoff.RecordFailure(ctx, account); err != nil {
+		return err
+	}
+	return s.ipBackoff.RecordFailure(ctx, ip)
+}
+
+func (s *AuthService) createSession(ctx context.Context, userID, userAgent, ip string, rememberMe bool) (*repository.Session, error) {
+	idle, absolute := s.sessionConfig.timeouts(rememberMe)
+	now := time.Now()
+	return s.sessionRepo.Create(ctx, &repository.CreateSessionRequest{
+		UserID:            userID,
+		UserAgent:         userAgent,
+		IP:                ip,
+		RememberMe:        rememberMe,
+		ExpiresAt:         now.Add(idle),
+		AbsoluteExpiresAt: now.Add(absolute),
+	})
+}
+
+// lastSeenResolution limits how often a session's activity is written.
+const lastSeenResolution = time.Minute
+
+// Authenticate returns the session if it is still valid and slides its idle
+// deadline forward. Expired sessions are deleted.
+func (s *AuthService) Authenticate(ctx context.Context, sessionID string, ip string) (*repository.Session, error) {
+	session, err := s.sessionRepo.GetByID(ctx, sessionID)
+	if err != nil || session == nil {
+		return session, err
+	}
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.After(session.AbsoluteExpiresAt) {
+		if err := s.sessionRepo.Delete(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if now.Sub(session.LastSeenAt) > lastSeenResolution || session.IP != ip {
+		idle, _ := s.sessionConfig.timeouts(session.RememberMe)
+		session.LastSeenAt = now
+		session.IP = ip
+		session.ExpiresAt = now.Add(idle)
+		if session.ExpiresAt.After(session.AbsoluteExpiresAt) {
+			session.ExpiresAt = session.AbsoluteExpiresAt
+		}
+		if err := s.sessionRepo.Touch(ctx, session); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
 }
 
 func (s *AuthService) Logout(ctx context.Context, sessionID string) error {
 	return s.sessionRepo.Delete(ctx, sessionID)
 }
+
+// GetSessions returns the sessions that still sign the user in. Expired ones
+// are left out even before the janitor deletes them.
+func (s *AuthService) GetSessions(ctx context.Context, userID string) ([]*repository.Session, error) {
+	return s.sessionRepo.GetActiveByUserID(ctx, userID, time.Now())
+}
+
+func (s *AuthService) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	return s.sessionRepo.DeleteByUserID(ctx, userID, sessionID)
+}
+
+func (s *AuthService) RevokeOtherSessions(ctx context.Context, userID string, currentSessionID string) error {
+	return s.sessionRepo.DeleteOthersByUserID(ctx, userID, currentSessionID)
+}
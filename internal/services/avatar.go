@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gt/internal/imaging"
+	"gt/internal/repository"
+	"gt/internal/storage"
+	"image/png"
+	"io"
+	"log"
+	"strconv"
+)
+
+var (
+	ErrAvatarTooLarge      = errors.New("image must be at most 5 MB")
+	ErrAvatarNotAnImage    = errors.New("image must be a PNG, JPEG or GIF file")
+	ErrAvatarTooManyPixels = errors.New("image must be at most 4096 by 4096 pixels")
+)
+
+const (
+	MaxAvatarBytes  = 5 << 20
+	maxAvatarSide   = 4096
+	avatarKeyPrefix = "avatars/"
+)
+
+// AvatarSizes are the square sizes, in pixels, every avatar is stored in,
+// smallest first.
+var AvatarSizes = []int{64, 128, 256}
+
+type AvatarService struct {
+	userRepo *repository.UserRepository
+	storage  storage.Storage
+}
+
+func NewAvatarService(userRepo *repository.UserRepository, storage storage.Storage) *AvatarService {
+	return &AvatarService{userRepo: userRepo, storage: storage}
+}
+
+func avatarKey(userID string, avatarID string, size int) string {
+	return avatarKeyPrefix + userID + "/" + avatarID + "-" + strconv.Itoa(size) + ".png"
+}
+
+// Upload crops the image to a centered square, stores it in every size of
+// AvatarSizes and makes it the user's avatar. The files are named after the
+// hash of the uploaded image, so a new upload never overwrites a file that
+// may still be cached. The previous avatar's files are removed afterwards.
+func (s *AvatarService) Upload(ctx context.Context, user *repository.User, r io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(r, MaxAvatarBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxAvatarBytes {
+		return ErrAvatarTooLarge
+	}
+	img, err := imaging.Decode(data, maxAvatarSide)
+	switch {
+	case errors.Is(err, imaging.ErrTooManyPixels):
+		return ErrAvatarTooManyPixels
+	case err != nil:
+		return ErrAvatarNotAnImage
+	}
+	square := imaging.CropSquare(img)
+
+	avatarID := storage.ContentKey("", data, "")
+	for _, size := range AvatarSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, imaging.Resize(square, size, size)); err != nil {
+			return err
+		}
+		if err := s.storage.Put(ctx, avatarKey(user.ID, avatarID, size), &buf, "image/png"); err != nil {
+			return err
+		}
+	}
+	if err := s.userRepo.UpdateAvatar(ctx, user.ID, avatarID); err != nil {
+		return err
+	}
+	previous := user.AvatarID
+	user.AvatarID = avatarID
+	if previous != avatarID {
+		s.deleteFiles(ctx, user.ID, previous)
+	}
+	return nil
+}
+
+// Remove clears the user's avatar and deletes its files.
+func (s *AvatarService) Remove(ctx context.Context, user *repository.User) error {
+	if user.AvatarID == "" {
+		return nil
+	}
+	if err := s.userRepo.UpdateAvatar(ctx, user.ID, ""); err != nil {
+		return err
+	}
+	previous := user.AvatarID
+	user.AvatarID = ""
+	s.deleteFiles(ctx, user.ID, previous)
+	return nil
+}
+
+// deleteFiles removes the files of an avatar that is no longer used. Failures
+// only leave orphaned files behind, so they are logged rather than returned.
+func (s *AvatarService) deleteFiles(ctx context.Context, userID string, avatarID string) {
+	if avatarID == "" {
+		return
+	}
+	for _, size := range AvatarSizes {
+		if err := s.storage.Delete(ctx, avatarKey(userID, avatarID, size)); err != nil {
+			log.Printf("failed to delete avatar %s of user %s: %v", avatarID, userID, err)
+		}
+	}
+}
+
+// URL returns the URL of the user's avatar in the smallest stored size that
+// is at least size pixels, or an empty string if they have none.
+func (s *AvatarService) URL(user *repository.User, size int) string {
+	if user.AvatarID == "" {
+		return ""
+	}
+	stored := AvatarSizes[len(AvatarSizes)-1]
+	for _, candidate := range AvatarSizes {
+		if candidate >= size {
+			stored = candidate
+			break
+		}
+	}
+	return s.storage.URL(avatarKey(user.ID, user.AvatarID, stored))
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"gt/internal/repository"
+	"io"
+	"time"
+)
+
+// DataExport is everything the service stores about a user, in the form it
+// is handed to them. Secrets such as the password hash are left out.
+type DataExport struct {
+	ExportedAt   time.Time               `json:"exported_at"`
+	Profile      DataExportProfile       `json:"profile"`
+	Sessions     []DataExportSession     `json:"sessions"`
+	GameLogins   []DataExportGameLogin   `json:"game_logins"`
+	Achievements []DataExportAchievement `json:"achievements"`
+	Friends      []string                `json:"friends"`
+}
+
+type DataExportProfile struct {
+	ID                  string     `json:"id"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email"`
+	EmailVerified       bool       `json:"email_verified"`
+	TwoFactorEnabled    bool       `json:"two_factor_enabled"`
+	UsernameChangedAt   *time.Time `json:"username_changed_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	ProfileVisibility   string     `json:"profile_visibility"`
+	AvatarURL           string     `json:"avatar_url,omitempty"`
+}
+
+type DataExportSession struct {
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type DataExportGameLogin struct {
+	Game       string     `json:"game"`
+	DeviceInfo string     `json:"device_info"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type DataExportAchievement struct {
+	Game        string    `json:"game"`
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Points      int       `json:"points"`
+	UnlockedAt  time.Time `json:"unlocked_at"`
+}
+
+// Export collects the user's data for download.
+func (s *AccountService) Export(ctx context.Context, user *repository.User) (*DataExport, error) {
+	sessions, err := s.sessionRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	gameLogins, err := s.gameLoginRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	achievements, err := s.achievementRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	friends, err := s.friendshipRepo.GetFriends(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export := &DataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: DataExportProfile{
+			ID:                  user.ID,
+			Username:            user.Username,
+			Email:               user.Email,
+			EmailVerified:       user.EmailVerified,
+			TwoFactorEnabled:    user.TOTPEnabled,
+			UsernameChangedAt:   user.UsernameChangedAt,
+			DeletionScheduledAt: user.DeletionScheduledAt,
+			ProfileVisibility:   string(user.ProfileVisibility),
+			AvatarURL:           s.avatarService.URL(user, AvatarSizes[len(AvatarSizes)-1]),
+		},
+		Sessions:     make([]DataExportSession, 0, len(sessions)),
+		GameLogins:   make([]DataExportGameLogin, 0, len(gameLogins)),
+		Achievements: make([]DataExportAchievement, 0, len(achievements)),
+		Friends:      make([]string, 0, len(friends)),
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, DataExportSession{
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+		})
+	}
+	for _, gameLogin := range gameLogins {
+		export.GameLogins = append(export.GameLogins, DataExportGameLogin{
+			Game:       gameLogin.Game.Name,
+			DeviceInfo: gameLogin.DeviceInfo,
+			CreatedAt:  gameLogin.CreatedAt,
+			LastUsedAt: gameLogin.LastUsedAt,
+			RevokedAt:  gameLogin.RevokedAt,
+		})
+	}
+	for _, achievement := range achievements {
+		definition := achievement.Definition
+		export.Achievements = append(export.Achievements, DataExportAchievement{
+			Game:        definition.Game.Name,
+			Key:         definition.Key,
+			Name:        definition.Name,
+			Description: definition.Description,
+			Points:      definition.Points,
+			UnlockedAt:  achievement.CreatedAt,
+		})
+	}
+	for _, friend := range friends {
+		export.Friends = append(export.Friends, friend.Username)
+	}
+	return export, nil
+}
+
+// WriteJSON writes the export as a single indented JSON document.
+func (e *DataExport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(e)
+}
+
+// WriteZIP writes the export as a ZIP archive with one JSON file per part.
+func (e *DataExport) WriteZIP(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", e.Profile},
+		{"sessions.json", e.Sessions},
+		{"game_logins.json", e.GameLogins},
+		{"achievements.json", e.Achievements},
+		{"friends.json", e.Friends},
+	}
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: e.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/mail"
+	"gt/internal/repository"
+	"gt/internal/security"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+	ErrEmailTaken               = errors.New("email is already in use")
+)
+
+const (
+	emailVerificationPurpose = "email-verification"
+	emailVerificationTTL     = 48 * time.Hour
+)
+
+type EmailVerificationService struct {
+	userRepo         *repository.UserRepository
+	verificationRepo *repository.EmailVerificationRepository
+	mailer           mail.Sender
+	signer           *security.Signer
+	sendLimiter      *AttemptLimiter
+	baseURL          string
+}
+
+func NewEmailVerificationService(
+	userRepo *repository.UserRepository,
+	verificationRepo *repository.EmailVerificationRepository,
+	mailer mail.Sender,
+	signer *security.Signer,
+	sendLimiter *AttemptLimiter,
+	baseURL string,
+) *EmailVerificationService {
+	return &EmailVerificationService{
+		userRepo:         userRepo,
+		verificationRepo: verificationRepo,
+		mailer:           mailer,
+		signer:           signer,
+		sendLimiter:      sendLimiter,
+		baseURL:          baseURL,
+	}
+}
+
+// SendVerification mails the user a link that verifies their current email.
+func (s *EmailVerificationService) SendVerification(ctx context.Context, user *repository.User) error {
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	if err := s.sendLimiter.Check(ctx, "user:"+user.ID); err != nil {
+		return err
+	}
+	// Every mail sent counts against the limit, not just failures.
+	if err := s.sendLimiter.RecordFailure(ctx, "user:"+user.ID); err != nil {
+		return err
+	}
+	verification, err := s.verificationRepo.Create(ctx, &repository.CreateEmailVerificationRequest{
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+	link := s.baseURL + "/verify-email?" + url.Values{
+		"token": []string{s.signer.Sign(emailVerificationPurpose, verification.ID)},
+	}.Encode()
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Open the link below to verify your email address:\n\n" +
+			link + "\n\n" +
+			"The link expires in 48 hours. If you didn't sign up, you can ignore this email.\n",
+	})
+}
+
+// SendEmailChange mails a link to newEmail that, once opened, replaces the
+// user's email with it. Earlier change links stop working.
+func (s *EmailVerificationService) SendEmailChange(ctx context.Context, user *repository.User, newEmail string) error {
+	if err := s.sendLimiter.Check(ctx, "user:"+user.ID); err != nil {
+		return err
+	}
+	if err := s.sendLimiter.RecordFailure(ctx, "user:"+user.ID); err != nil {
+		return err
+	}
+	if err := s.verificationRepo.MarkChangesUsedByUserID(ctx, user.ID, time.Now()); err != nil {
+		return err
+	}
+	verification, err := s.verificationRepo.Create(ctx, &repository.CreateEmailVerificationRequest{
+		UserID:    user.ID,
+		Email:     newEmail,
+		Change:    true,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+	link := s.baseURL + "/verify-email?" + url.Values{
+		"token": []string{s.signer.Sign(emailVerificationPurpose, verification.ID)},
+	}.Encode()
+	return s.mailer.Send(ctx, mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Open the link below to use this address for your account:\n\n" +
+			link + "\n\n" +
+			"The link expires in 48 hours. If you didn't ask for this, you can ignore this email.\n",
+	})
+}
+
+// Verify consumes the token and marks the email it was sent to as verified,
+// switching the user to it first if it confirms an email change. Tokens for
+// an address the user has since changed are rejected.
+func (s *EmailVerificationService) Verify(ctx context.Context, token string) (*repository.User, error) {
+	id, ok := s.signer.Verify(emailVerificationPurpose, token)
+	if !ok {
+		return nil, ErrInvalidVerificationToken
+	}
+	verification, err := s.verificationRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if verification == nil || verification.UsedAt != nil || now.After(verification.ExpiresAt) {
+		return nil, ErrInvalidVerificationToken
+	}
+	used, err := s.verificationRepo.MarkUsed(ctx, verification.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, ErrInvalidVerificationToken
+	}
+	if verification.Change {
+		// The address was free when the change was requested, but someone
+		// may have signed up with it since.
+		existing, err := s.userRepo.GetByEmail(ctx, verification.Email)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && existing.ID != verification.UserID {
+			return nil, ErrEmailTaken
+		}
+		if err := s.userRepo.ChangeEmail(ctx, verification.UserID, verification.Email); err != nil {
+			return nil, err
+		}
+		verification.User.Email = verification.Email
+		verification.User.EmailVerified = true
+		return verification.User, nil
+	}
+	verified, err := s.userRepo.MarkEmailVerified(ctx, verification.UserID, verification.Email)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		return nil, ErrInvalidVerificationToken
+	}
+	verification.User.EmailVerified = true
+	return verification.User, nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/repository"
+	"time"
+)
+
+var ErrCannotFriendSelf = errors.New("you can't add yourself as a friend")
+
+// FriendshipStatus describes the friendship between a user and someone else
+// from the user's point of view.
+type FriendshipStatus int
+
+const (
+	FriendshipNone FriendshipStatus = iota
+	// FriendshipRequested means the user sent a request that is pending.
+	FriendshipRequested
+	// FriendshipIncoming means the other user sent a request that is
+	// pending.
+	FriendshipIncoming
+	FriendshipFriends
+)
+
+type FriendService struct {
+	friendshipRepo *repository.FriendshipRepository
+}
+
+func NewFriendService(friendshipRepo *repository.FriendshipRepository) *FriendService {
+	return &FriendService{friendshipRepo: friendshipRepo}
+}
+
+func (s *FriendService) Status(ctx context.Context, userID string, otherID string) (FriendshipStatus, error) {
+	friendship, err := s.friendshipRepo.GetBetween(ctx, userID, otherID)
+	if err != nil {
+		return FriendshipNone, err
+	}
+	switch {
+	case friendship == nil:
+		return FriendshipNone, nil
+	case friendship.AcceptedAt != nil:
+		return FriendshipFriends, nil
+	case friendship.RequesterID == userID:
+		return FriendshipRequested, nil
+	default:
+		return FriendshipIncoming, nil
+	}
+}
+
+// AddFriend sends a friend request to other, or accepts theirs if they
+// already sent one.
+func (s *FriendService) AddFriend(ctx context.Context, user *repository.User, other *repository.User) error {
+	if user.ID == other.ID {
+		return ErrCannotFriendSelf
+	}
+	status, err := s.Status(ctx, user.ID, other.ID)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case FriendshipNone:
+		_, err = s.friendshipRepo.Create(ctx, user.ID, other.ID)
+	case FriendshipIncoming:
+		_, err = s.friendshipRepo.Accept(ctx, other.ID, user.ID, time.Now())
+	}
+	return err
+}
+
+// RemoveFriend ends the friendship with other, or withdraws or declines a
+// pending request.
+func (s *FriendService) RemoveFriend(ctx context.Context, user *repository.User, other *repository.User) error {
+	return s.friendshipRepo.DeleteBetween(ctx, user.ID, other.ID)
+}
+
+func (s *FriendService) GetFriends(ctx context.Context, userID string) ([]*repository.User, error) {
+	return s.friendshipRepo.GetFriends(ctx, userID)
+}
+
+func (s *FriendService) GetIncomingRequests(ctx context.Context, userID string) ([]*repository.Friendship, error) {
+	return s.friendshipRepo.GetIncoming(ctx, userID)
+}
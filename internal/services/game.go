@@ -3,43 +3,227 @@ package services
 import (
 	"context"
 	"errors"
+	"gt/internal/events"
 	"gt/internal/repository"
 	"gt/internal/security"
+	"regexp"
+	"strings"
 	"time"
 )
 
 type GameService struct {
+	gameRepo             *repository.GameRepository
 	gameLoginRepo        *repository.GameLoginRepository
 	gameLoginRequestRepo *repository.GameLoginRequestRepository
+	refreshTokenRepo     *repository.GameLoginRefreshTokenRepository
+	activateLimiter      *AttemptLimiter
+	stateEvents          *events.Broker
 }
 
-func NewGameService(gameLoginRepo *repository.GameLoginRepository, gameLoginRequestRepo *repository.GameLoginRequestRepository) *GameService {
-	return &GameService{gameLoginRepo: gameLoginRepo, gameLoginRequestRepo: gameLoginRequestRepo}
+func NewGameService(gameRepo *repository.GameRepository, gameLoginRepo *repository.GameLoginRepository, gameLoginRequestRepo *repository.GameLoginRequestRepository, refreshTokenRepo *repository.GameLoginRefreshTokenRepository, activateLimiter *AttemptLimiter, stateEvents *events.Broker) *GameService {
+	return &GameService{
+		gameRepo:             gameRepo,
+		gameLoginRepo:        gameLoginRepo,
+		gameLoginRequestRepo: gameLoginRequestRepo,
+		refreshTokenRepo:     refreshTokenRepo,
+		activateLimiter:      activateLimiter,
+		stateEvents:          stateEvents,
+	}
 }
 
 var (
+	ErrGameNotFound             = errors.New("game not found")
+	ErrGameAlreadyExists        = errors.New("game with this slug already exists")
+	ErrInvalidGameSlug          = errors.New("slug must be 3-32 characters of lowercase letters, digits and dashes")
+	ErrInvalidGameName          = errors.New("game name is required")
+	ErrInvalidGameCredentials   = errors.New("invalid game client credentials")
 	ErrGameLoginRequestNotFound = errors.New("game login request not found")
 	ErrGameLoginRequestUsed     = errors.New("game login request already used")
 	ErrGameLoginCodeNotFound    = errors.New("game login code not found")
+	ErrAuthorizationPending     = errors.New("authorization pending")
+	ErrSlowDown                 = errors.New("polling too frequently")
+	ErrDeviceCodeExpired        = errors.New("device code expired")
+	ErrInvalidDeviceCode        = errors.New("invalid device code")
+	ErrAccessDenied             = errors.New("user denied the game login request")
 )
 
+// slowDownIncrement is added to the polling interval each time a device polls
+// too early, as required by RFC 8628 section 3.5.
+const slowDownIncrement = 5
+
+// maxUserCodeAttempts is how many user codes are tried for a login request.
+const maxUserCodeAttempts = 5
+
 type CreatedGameLoginRequest struct {
 	GameLoginRequest *repository.GameLoginRequest
 	Token            string
 }
 
-func (s *GameService) CreateGameLoginRequest(ctx context.Context) (*CreatedGameLoginRequest, error) {
-	token := security.GenerateToken()
-	hashedToken, err := security.HashPassword(token)
+// DeviceCode combines the request ID and its secret token into the opaque
+// device_code handed to games using the device authorization grant.
+func (c *CreatedGameLoginRequest) DeviceCode() string {
+	return c.GameLoginRequest.ID + "." + c.Token
+}
+
+func (s *GameService) GetGameBySlug(ctx context.Context, slug string) (*repository.Game, error) {
+	game, err := s.gameRepo.GetBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if game == nil {
+		return nil, ErrGameNotFound
+	}
+	return game, nil
+}
+
+var gameSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{2,31}$`)
+
+func validateGame(slug, name string) error {
+	if !gameSlugPattern.MatchString(slug) {
+		return ErrInvalidGameSlug
+	}
+	if strings.TrimSpace(name) == "" {
+		return ErrInvalidGameName
+	}
+	return nil
+}
+
+type CreateGameRequest struct {
+	OwnerID string
+	Slug    string
+	Name    string
+	IconURL string
+}
+
+// GameWithSecret carries a plaintext client secret that is only available
+// right after it is issued.
+type GameWithSecret struct {
+	Game         *repository.Game
+	ClientSecret string
+}
+
+func (s *GameService) CreateGame(ctx context.Context, req *CreateGameRequest) (*GameWithSecret, error) {
+	if err := validateGame(req.Slug, req.Name); err != nil {
+		return nil, err
+	}
+	existing, err := s.gameRepo.GetBySlug(ctx, req.Slug)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrGameAlreadyExists
+	}
+	secret := security.GenerateToken()
+	hashedSecret, err := security.HashPassword(secret)
 	if err != nil {
 		return nil, err
 	}
-	gameLoginRequest, err := s.gameLoginRequestRepo.Create(ctx, &repository.CreateGameLoginRequestRequest{
-		Token: string(hashedToken),
+	game, err := s.gameRepo.Create(ctx, &repository.CreateGameRequest{
+		OwnerID:      req.OwnerID,
+		Slug:         req.Slug,
+		Name:         req.Name,
+		IconURL:      req.IconURL,
+		ClientID:     security.GenerateToken(),
+		ClientSecret: hashedSecret,
 	})
 	if err != nil {
 		return nil, err
 	}
+	return &GameWithSecret{Game: game, ClientSecret: secret}, nil
+}
+
+// RotateClientSecret issues a new client secret, invalidating the old one.
+func (s *GameService) RotateClientSecret(ctx context.Context, game *repository.Game) (*GameWithSecret, error) {
+	secret := security.GenerateToken()
+	hashedSecret, err := security.HashPassword(secret)
+	if err != nil {
+		return nil, err
+	}
+	game.ClientSecret = hashedSecret
+	if err := s.gameRepo.Update(ctx, game); err != nil {
+		return nil, err
+	}
+	return &GameWithSecret{Game: game, ClientSecret: secret}, nil
+}
+
+func (s *GameService) AuthenticateGame(ctx context.Context, clientID string, clientSecret string) (*repository.Game, error) {
+	game, err := s.gameRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if game == nil || !security.CheckPasswordHash(clientSecret, game.ClientSecret) {
+		return nil, ErrInvalidGameCredentials
+	}
+	return game, nil
+}
+
+func (s *GameService) GetGamesByOwnerID(ctx context.Context, ownerID string) ([]*repository.Game, error) {
+	return s.gameRepo.GetByOwnerID(ctx, ownerID)
+}
+
+// GetOwnedGame returns the game only if it belongs to ownerID, so other
+// studios cannot tell whether the ID exists.
+func (s *GameService) GetOwnedGame(ctx context.Context, id string, ownerID string) (*repository.Game, error) {
+	game, err := s.gameRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if game == nil || game.OwnerID != ownerID {
+		return nil, ErrGameNotFound
+	}
+	return game, nil
+}
+
+func (s *GameService) UpdateGame(ctx context.Context, game *repository.Game) error {
+	if err := validateGame(game.Slug, game.Name); err != nil {
+		return err
+	}
+	if err := validateWebhookURL(game.WebhookURL); err != nil {
+		return err
+	}
+	// The signing secret is created along with the first webhook URL.
+	if game.WebhookURL != "" && game.WebhookSecret == "" {
+		game.WebhookSecret = security.GenerateToken()
+	}
+	return s.gameRepo.Update(ctx, game)
+}
+
+// RotateWebhookSecret replaces the secret webhook deliveries are signed with.
+func (s *GameService) RotateWebhookSecret(ctx context.Context, game *repository.Game) error {
+	game.WebhookSecret = security.GenerateToken()
+	return s.gameRepo.Update(ctx, game)
+}
+
+func (s *GameService) GetLinkedPlayerCount(ctx context.Context, gameID string) (int64, error) {
+	return s.gameLoginRepo.CountUsersByGameID(ctx, gameID)
+}
+
+// CreateGameLoginRequest starts a login for game. deviceInfo describes the
+// console or client and is shown to the user in their settings.
+func (s *GameService) CreateGameLoginRequest(ctx context.Context, game *repository.Game, deviceInfo string) (*CreatedGameLoginRequest, error) {
+	token := security.GenerateToken()
+	hashedToken, err := security.HashPassword(token)
+	if err != nil {
+		return nil, err
+	}
+	// User codes are short enough to collide now and then, so a taken code
+	// is replaced with a new one.
+	var gameLoginRequest *repository.GameLoginRequest
+	for attempt := 0; gameLoginRequest == nil; attempt++ {
+		if attempt == maxUserCodeAttempts {
+			return nil, errors.New("failed to generate a unique user code")
+		}
+		gameLoginRequest, err = s.gameLoginRequestRepo.Create(ctx, &repository.CreateGameLoginRequestRequest{
+			GameID:     game.ID,
+			Token:      string(hashedToken),
+			UserCode:   security.GenerateUserCode(),
+			DeviceInfo: deviceInfo,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	gameLoginRequest.Game = game
 	return &CreatedGameLoginRequest{
 		GameLoginRequest: gameLoginRequest,
 		Token:            token,
@@ -47,7 +231,7 @@ func (s *GameService) CreateGameLoginRequest(ctx context.Context) (*CreatedGameL
 }
 
 func verifyGameLoginRequest(req *repository.GameLoginRequest) error {
-	if req.User != nil || req.ExpiresAt.Before(time.Now()) {
+	if req.Status != repository.GameLoginRequestPending || req.ExpiresAt.Before(time.Now()) {
 		return ErrGameLoginRequestUsed
 	}
 	return nil
@@ -67,12 +251,43 @@ func (s *GameService) GetGameLoginRequest(ctx context.Context, id string) (*repo
 	return req, nil
 }
 
+type ActivateRequest struct {
+	UserCode string
+	UserID   string
+	IP       string
+}
+
+// Activate resolves a user code typed on the activation page. Failed lookups
+// are counted per user and per IP so codes cannot be guessed.
+func (s *GameService) Activate(ctx context.Context, req ActivateRequest) (*repository.GameLoginRequest, error) {
+	keys := []string{"user:" + req.UserID, "ip:" + req.IP}
+	if err := s.activateLimiter.Check(ctx, keys...); err != nil {
+		return nil, err
+	}
+	var gameLoginRequest *repository.GameLoginRequest
+	userCode, ok := security.NormalizeUserCode(req.UserCode)
+	if ok {
+		var err error
+		gameLoginRequest, err = s.gameLoginRequestRepo.GetByUserCode(ctx, userCode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if gameLoginRequest == nil || verifyGameLoginRequest(gameLoginRequest) != nil {
+		if err := s.activateLimiter.RecordFailure(ctx, keys...); err != nil {
+			return nil, err
+		}
+		return nil, ErrGameLoginRequestNotFound
+	}
+	return gameLoginRequest, nil
+}
+
 func (s *GameService) GetGameLoginRequestState(ctx context.Context, id string, token string) (*repository.GameLoginRequest, error) {
 	req, err := s.gameLoginRequestRepo.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	if req == nil || req.ExpiresAt.Before(time.Now()) || req.GameLogin != nil {
+	if req == nil || req.ExpiresAt.Before(time.Now()) || req.GameLogin != nil || req.Status == repository.GameLoginRequestCancelled {
 		return nil, ErrGameLoginRequestNotFound
 	}
 	if !security.CheckPasswordHash(token, req.Token) {
@@ -92,13 +307,97 @@ func (s *GameService) Login(ctx context.Context, gameLoginRequestID string, user
 	if err := verifyGameLoginRequest(req); err != nil {
 		return err
 	}
-	req.UserID = &user.ID
-	return s.gameLoginRequestRepo.Update(ctx, req)
+	return s.updateStatus(ctx, req, repository.GameLoginRequestApproved, &user.ID, ErrGameLoginRequestUsed, repository.GameLoginRequestPending)
+}
+
+func (s *GameService) Deny(ctx context.Context, gameLoginRequestID string) error {
+	req, err := s.gameLoginRequestRepo.GetByID(ctx, gameLoginRequestID)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return ErrGameLoginRequestNotFound
+	}
+	if err := verifyGameLoginRequest(req); err != nil {
+		return err
+	}
+	return s.updateStatus(ctx, req, repository.GameLoginRequestDenied, nil, ErrGameLoginRequestUsed, repository.GameLoginRequestPending)
+}
+
+// Cancel lets the game withdraw its own request before it has been exchanged.
+func (s *GameService) Cancel(ctx context.Context, gameLoginRequestID string, token string) error {
+	req, err := s.gameLoginRequestRepo.GetByID(ctx, gameLoginRequestID)
+	if err != nil {
+		return err
+	}
+	if req == nil || req.GameLogin != nil || req.Status == repository.GameLoginRequestCancelled {
+		return ErrGameLoginRequestNotFound
+	}
+	if !security.CheckPasswordHash(token, req.Token) {
+		return ErrGameLoginRequestNotFound
+	}
+	return s.updateStatus(ctx, req, repository.GameLoginRequestCancelled, nil, ErrGameLoginRequestNotFound,
+		repository.GameLoginRequestPending, repository.GameLoginRequestApproved, repository.GameLoginRequestDenied)
 }
 
-type GameLoginExchanged struct {
-	GameLogin *repository.GameLogin
-	Token     string
+// updateStatus moves the request to status if it is still in one of the from
+// statuses and notifies every server instance watching it. The check is made
+// by the update itself, so a concurrent change is never overwritten; conflict
+// is returned when it loses such a race.
+func (s *GameService) updateStatus(ctx context.Context, req *repository.GameLoginRequest, status repository.GameLoginRequestStatus, userID *string, conflict error, from ...repository.GameLoginRequestStatus) error {
+	updated, err := s.gameLoginRequestRepo.UpdateStatus(ctx, req.ID, from, status, userID)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return conflict
+	}
+	req.Status = status
+	if userID != nil {
+		req.UserID = userID
+	}
+	return s.gameLoginRequestRepo.NotifyStateChanged(ctx, req.ID)
+}
+
+// WatchGameLoginRequestState verifies the token once and then streams the
+// request every time its status changes. The channel is closed once the
+// request leaves the pending state, expires, or ctx is done.
+func (s *GameService) WatchGameLoginRequestState(ctx context.Context, id string, token string) (<-chan *repository.GameLoginRequest, error) {
+	req, err := s.GetGameLoginRequestState(ctx, id, token)
+	if err != nil {
+		return nil, err
+	}
+	notifications, unsubscribe := s.stateEvents.Subscribe(req.ID)
+	states := make(chan *repository.GameLoginRequest)
+	go func() {
+		defer close(states)
+		defer unsubscribe()
+		expired := time.NewTimer(time.Until(req.ExpiresAt))
+		defer expired.Stop()
+		for {
+			// Reload after subscribing so a change made in between is not missed.
+			current, err := s.gameLoginRequestRepo.GetByID(ctx, id)
+			if err != nil || current == nil {
+				return
+			}
+			select {
+			case states <- current:
+			case <-ctx.Done():
+				return
+			}
+			if current.Status != repository.GameLoginRequestPending {
+				return
+			}
+			select {
+			case <-notifications:
+			case <-expired.C:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return states, nil
 }
 
 func (s *GameService) Exchange(ctx context.Context, gameRequestId string, token string) (*GameLoginExchanged, error) {
@@ -112,30 +411,53 @@ func (s *GameService) Exchange(ctx context.Context, gameRequestId string, token
 	if !security.CheckPasswordHash(token, req.Token) {
 		return nil, ErrGameLoginRequestNotFound
 	}
-	userID := req.UserID
-	if userID == nil {
+	if req.Status == repository.GameLoginRequestDenied {
+		return nil, ErrAccessDenied
+	}
+	if req.Status != repository.GameLoginRequestApproved || req.UserID == nil {
 		return nil, errors.New("game login request has no user ID")
 	}
-	loginToken := security.GenerateToken()
-	hashedToken, err := security.HashPassword(loginToken)
-	if err != nil {
-		return nil, err
+	return s.createGameLogin(ctx, req)
+}
+
+// ExchangeDeviceCode implements the polling side of the RFC 8628 device
+// authorization grant for the given authenticated game.
+func (s *GameService) ExchangeDeviceCode(ctx context.Context, game *repository.Game, deviceCode string) (*GameLoginExchanged, error) {
+	id, token, ok := strings.Cut(deviceCode, ".")
+	if !ok {
+		return nil, ErrInvalidDeviceCode
 	}
-	gameLogin, err := s.gameLoginRepo.Create(ctx, &repository.CreateGameLoginRequest{
-		UserID: *userID,
-		Token:  string(hashedToken),
-	})
+	req, err := s.gameLoginRequestRepo.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	req.GameLogin = gameLogin
-	if err := s.gameLoginRequestRepo.Update(ctx, req); err != nil {
-		return nil, err
+	if req == nil || req.GameID != game.ID || req.GameLogin != nil || req.Status == repository.GameLoginRequestCancelled {
+		return nil, ErrInvalidDeviceCode
 	}
-	return &GameLoginExchanged{
-		GameLogin: gameLogin,
-		Token:     loginToken,
-	}, nil
+	if !security.CheckPasswordHash(token, req.Token) {
+		return nil, ErrInvalidDeviceCode
+	}
+	if req.Status == repository.GameLoginRequestDenied {
+		return nil, ErrAccessDenied
+	}
+	now := time.Now()
+	if req.ExpiresAt.Before(now) {
+		return nil, ErrDeviceCodeExpired
+	}
+	tooEarly := req.LastPolledAt != nil && now.Sub(*req.LastPolledAt) < time.Duration(req.Interval)*time.Second
+	if tooEarly {
+		req.Interval += slowDownIncrement
+	}
+	if tooEarly || req.Status != repository.GameLoginRequestApproved {
+		if err := s.gameLoginRequestRepo.RecordPoll(ctx, req.ID, now, req.Interval); err != nil {
+			return nil, err
+		}
+		if tooEarly {
+			return nil, ErrSlowDown
+		}
+		return nil, ErrAuthorizationPending
+	}
+	return s.createGameLogin(ctx, req)
 }
 
 func (s *GameService) AuthenticateGameLogin(ctx context.Context, id string, token string) (*repository.GameLogin, error) {
@@ -143,8 +465,44 @@ func (s *GameService) AuthenticateGameLogin(ctx context.Context, id string, toke
 	if err != nil {
 		return nil, err
 	}
-	if gameLogin == nil || !security.CheckPasswordHash(token, gameLogin.Token) {
+	if gameLogin == nil || gameLogin.RevokedAt != nil || gameLogin.ExpiresAt.Before(time.Now()) {
+		return nil, ErrGameLoginCodeNotFound
+	}
+	if !security.CheckPasswordHash(token, gameLogin.Token) {
 		return nil, ErrGameLoginCodeNotFound
 	}
+	now := time.Now()
+	if gameLogin.LastUsedAt == nil || now.Sub(*gameLogin.LastUsedAt) > lastUsedResolution {
+		if err := s.gameLoginRepo.TouchLastUsed(ctx, gameLogin.ID, now); err != nil {
+			return nil, err
+		}
+		gameLogin.LastUsedAt = &now
+	}
 	return gameLogin, nil
 }
+
+func (s *GameService) AuthenticateAccessToken(ctx context.Context, accessToken string) (*repository.GameLogin, error) {
+	id, token, ok := strings.Cut(accessToken, ".")
+	if !ok {
+		return nil, ErrGameLoginCodeNotFound
+	}
+	return s.AuthenticateGameLogin(ctx, id, token)
+}
+
+func (s *GameService) GetActiveGameLogins(ctx context.Context, userID string) ([]*repository.GameLogin, error) {
+	return s.gameLoginRepo.GetActiveByUserID(ctx, userID)
+}
+
+// RevokeGameLogin revokes one of the user's game logins. RequireGameLogin
+// rejects its credentials from then on.
+func (s *GameService) RevokeGameLogin(ctx context.Context, id string, userID string) error {
+	gameLogin, err := s.gameLoginRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if gameLogin == nil || gameLogin.UserID != userID {
+		return ErrGameLoginCodeNotFound
+	}
+	_, err = s.gameLoginRepo.Revoke(ctx, gameLogin.ID, time.Now())
+	return err
+}
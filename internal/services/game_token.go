@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/repository"
+	"gt/internal/security"
+	"strings"
+	"time"
+)
+
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// lastUsedResolution limits how often LastUsedAt is written for a login.
+	lastUsedResolution = time.Minute
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, game login revoked")
+)
+
+type GameLoginExchanged struct {
+	GameLogin    *repository.GameLogin
+	Token        string
+	RefreshToken string
+}
+
+// AccessToken combines the game login ID and token into a single bearer
+// token for OAuth clients.
+func (e *GameLoginExchanged) AccessToken() string {
+	return e.GameLogin.ID + "." + e.Token
+}
+
+// ExpiresIn returns the remaining lifetime of the access token in seconds.
+func (e *GameLoginExchanged) ExpiresIn() int {
+	return int(time.Until(e.GameLogin.ExpiresAt).Seconds())
+}
+
+func (s *GameService) createGameLogin(ctx context.Context, req *repository.GameLoginRequest) (*GameLoginExchanged, error) {
+	loginToken := security.GenerateToken()
+	hashedToken, err := security.HashPassword(loginToken)
+	if err != nil {
+		return nil, err
+	}
+	gameLogin, err := s.gameLoginRepo.Create(ctx, &repository.CreateGameLoginRequest{
+		UserID:     *req.UserID,
+		GameID:     req.GameID,
+		Token:      string(hashedToken),
+		DeviceInfo: req.DeviceInfo,
+		ExpiresAt:  time.Now().Add(accessTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := s.createRefreshToken(ctx, gameLogin.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Only one exchange of a request may hand out credentials. A login
+	// created by a losing concurrent exchange is never returned, so its
+	// tokens are unknown to anyone.
+	claimed, err := s.gameLoginRequestRepo.SetGameLogin(ctx, req.ID, gameLogin.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrGameLoginRequestUsed
+	}
+	req.GameLogin = gameLogin
+	return &GameLoginExchanged{
+		GameLogin:    gameLogin,
+		Token:        loginToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *GameService) createRefreshToken(ctx context.Context, gameLoginID string) (string, error) {
+	token := security.GenerateToken()
+	hashedToken, err := security.HashPassword(token)
+	if err != nil {
+		return "", err
+	}
+	refreshToken, err := s.refreshTokenRepo.Create(ctx, &repository.CreateGameLoginRefreshTokenRequest{
+		GameLoginID: gameLoginID,
+		Token:       hashedToken,
+		ExpiresAt:   time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return refreshToken.ID + "." + token, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Presenting an already used refresh token revokes the whole login,
+// since it means the token chain has leaked.
+func (s *GameService) Refresh(ctx context.Context, refreshToken string) (*GameLoginExchanged, error) {
+	return s.refresh(ctx, refreshToken, "")
+}
+
+// RefreshForGame is Refresh for an authenticated game client; the token must
+// belong to that game.
+func (s *GameService) RefreshForGame(ctx context.Context, game *repository.Game, refreshToken string) (*GameLoginExchanged, error) {
+	return s.refresh(ctx, refreshToken, game.ID)
+}
+
+func (s *GameService) refresh(ctx context.Context, refreshToken string, gameID string) (*GameLoginExchanged, error) {
+	id, token, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	stored, err := s.refreshTokenRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || !security.CheckPasswordHash(token, stored.Token) {
+		return nil, ErrInvalidRefreshToken
+	}
+	gameLogin := stored.GameLogin
+	if gameLogin.RevokedAt != nil || (gameID != "" && gameLogin.GameID != gameID) {
+		return nil, ErrInvalidRefreshToken
+	}
+	now := time.Now()
+	if stored.UsedAt != nil {
+		return nil, s.revokeReused(ctx, gameLogin, now)
+	}
+	if stored.ExpiresAt.Before(now) {
+		return nil, ErrInvalidRefreshToken
+	}
+	marked, err := s.refreshTokenRepo.MarkUsed(ctx, stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		return nil, s.revokeReused(ctx, gameLogin, now)
+	}
+	loginToken := security.GenerateToken()
+	hashedToken, err := security.HashPassword(loginToken)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(accessTokenTTL)
+	rotated, err := s.gameLoginRepo.Rotate(ctx, gameLogin.ID, hashedToken, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// The login was revoked while the token was being checked.
+		return nil, ErrInvalidRefreshToken
+	}
+	gameLogin.Token = hashedToken
+	gameLogin.ExpiresAt = expiresAt
+	newRefreshToken, err := s.createRefreshToken(ctx, gameLogin.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &GameLoginExchanged{
+		GameLogin:    gameLogin,
+		Token:        loginToken,
+		RefreshToken: newRefreshToken,
+	}, nil
+}
+
+func (s *GameService) revokeReused(ctx context.Context, gameLogin *repository.GameLogin, now time.Time) error {
+	if _, err := s.gameLoginRepo.Revoke(ctx, gameLogin.ID, now); err != nil {
+		return err
+	}
+	gameLogin.RevokedAt = &now
+	return ErrRefreshTokenReused
+}
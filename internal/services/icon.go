@@ -0,0 +1,59 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gt/internal/storage"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrIconTooLarge   = errors.New("image must be at most 1 MB")
+	ErrIconNotAnImage = errors.New("image must be a PNG, JPEG, GIF or WebP file")
+)
+
+const (
+	MaxIconBytes  = 1 << 20
+	iconKeyPrefix = "icons/"
+)
+
+var iconExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// IconService stores the icons of games and achievements. Icons are stored
+// as uploaded and named after their content, so the same image uploaded
+// twice is stored once and files are never replaced.
+type IconService struct {
+	storage storage.Storage
+}
+
+func NewIconService(storage storage.Storage) *IconService {
+	return &IconService{storage: storage}
+}
+
+// Upload stores the image and returns its public URL.
+func (s *IconService) Upload(ctx context.Context, r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxIconBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxIconBytes {
+		return "", ErrIconTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := iconExtensions[contentType]
+	if !ok {
+		return "", ErrIconNotAnImage
+	}
+	key := storage.ContentKey(iconKeyPrefix, data, ext)
+	if err := s.storage.Put(ctx, key, bytes.NewReader(data), contentType); err != nil {
+		return "", err
+	}
+	return s.storage.URL(key), nil
+}
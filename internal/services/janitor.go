@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"gt/internal/repository"
+	"log"
+	"time"
+)
+
+// janitorLockKey identifies the advisory lock that keeps concurrent
+// replicas from running the cleanup at the same time.
+const janitorLockKey int64 = 0x67745f6a616e // "gt_jan"
+
+type JanitorConfig struct {
+	Interval  time.Duration
+	Retention time.Duration
+	BatchSize int
+}
+
+// JanitorReport holds the number of rows removed in a single run.
+type JanitorReport struct {
+	GameLoginRequests    int64
+	Sessions             int64
+	RefreshTokens        int64
+	Attempts             int64
+	EmailVerifications   int64
+	PasswordResets       int64
+	UsernameReservations int64
+	DeletedAccounts      int64
+	WebhookDeliveries    int64
+	WebhooksAttempted    int64
+}
+
+type JanitorService struct {
+	lockRepo                  *repository.LockRepository
+	gameLoginRequestRepo      *repository.GameLoginRequestRepository
+	sessionRepo               *repository.SessionRepository
+	gameLoginRefreshTokenRepo *repository.GameLoginRefreshTokenRepository
+	attemptRepo               *repository.AttemptRepository
+	emailVerificationRepo     *repository.EmailVerificationRepository
+	passwordResetRepo         *repository.PasswordResetRepository
+	usernameReservationRepo   *repository.UsernameReservationRepository
+	webhookDeliveryRepo       *repository.WebhookDeliveryRepository
+	accountService            *AccountService
+	webhookService            *WebhookService
+	config                    JanitorConfig
+}
+
+func NewJanitorService(
+	lockRepo *repository.LockRepository,
+	gameLoginRequestRepo *repository.GameLoginRequestRepository,
+	sessionRepo *repository.SessionRepository,
+	gameLoginRefreshTokenRepo *repository.GameLoginRefreshTokenRepository,
+	attemptRepo *repository.AttemptRepository,
+	emailVerificationRepo *repository.EmailVerificationRepository,
+	passwordResetRepo *repository.PasswordResetRepository,
+	usernameReservationRepo *repository.UsernameReservationRepository,
+	webhookDeliveryRepo *repository.WebhookDeliveryRepository,
+	accountService *AccountService,
+	webhookService *WebhookService,
+	config JanitorConfig,
+) *JanitorService {
+	return &JanitorService{
+		lockRepo:                  lockRepo,
+		gameLoginRequestRepo:      gameLoginRequestRepo,
+		sessionRepo:               sessionRepo,
+		gameLoginRefreshTokenRepo: gameLoginRefreshTokenRepo,
+		attemptRepo:               attemptRepo,
+		emailVerificationRepo:     emailVerificationRepo,
+		passwordResetRepo:         passwordResetRepo,
+		usernameReservationRepo:   usernameReservationRepo,
+		webhookDeliveryRepo:       webhookDeliveryRepo,
+		accountService:            accountService,
+		webhookService:            webhookService,
+		config:                    config,
+	}
+}
+
+// Run cleans up immediately and then once per interval until ctx is done.
+func (s *JanitorService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		s.runAndLog(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *JanitorService) runAndLog(ctx context.Context) {
+	report, ran, err := s.RunOnce(ctx)
+	if err != nil {
+		log.Printf("janitor: run failed: %v", err)
+		return
+	}
+	if !ran {
+		log.Printf("janitor: skipped, another instance holds the lock")
+		return
+	}
+	log.Printf("janitor: removed %d game login requests, %d sessions, %d refresh tokens, %d attempts, %d email verifications, %d password resets, %d username reservations, %d webhook deliveries; deleted %d accounts; attempted %d webhooks",
+		report.GameLoginRequests, report.Sessions, report.RefreshTokens, report.Attempts, report.EmailVerifications, report.PasswordResets, report.UsernameReservations, report.WebhookDeliveries,
+		report.DeletedAccounts, report.WebhooksAttempted)
+}
+
+// RunOnce purges expired rows, deletes accounts whose grace period is over
+// and sends pending webhooks, all in batches. It reports false if another
+// instance is already running the cleanup.
+func (s *JanitorService) RunOnce(ctx context.Context) (*JanitorReport, bool, error) {
+	report := &JanitorReport{}
+	ran, err := s.lockRepo.TryWithLock(ctx, janitorLockKey, func() error {
+		now := time.Now()
+		// Expired login requests and refresh tokens are kept for the retention
+		// period so late polls and refreshes still get a meaningful error.
+		cutoff := now.Add(-s.config.Retention)
+
+		var err error
+		if report.GameLoginRequests, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.gameLoginRequestRepo.DeleteExpired(ctx, cutoff, limit)
+		}); err != nil {
+			return err
+		}
+		if report.Sessions, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.sessionRepo.DeleteExpired(ctx, now, limit)
+		}); err != nil {
+			return err
+		}
+		if report.RefreshTokens, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.gameLoginRefreshTokenRepo.DeleteExpired(ctx, cutoff, limit)
+		}); err != nil {
+			return err
+		}
+		if report.Attempts, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.attemptRepo.DeleteBefore(ctx, cutoff, limit)
+		}); err != nil {
+			return err
+		}
+		if report.EmailVerifications, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.emailVerificationRepo.DeleteExpired(ctx, cutoff, limit)
+		}); err != nil {
+			return err
+		}
+		if report.PasswordResets, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.passwordResetRepo.DeleteExpired(ctx, cutoff, limit)
+		}); err != nil {
+			return err
+		}
+		if report.UsernameReservations, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.usernameReservationRepo.DeleteExpired(ctx, now, limit)
+		}); err != nil {
+			return err
+		}
+		if report.WebhookDeliveries, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.webhookDeliveryRepo.DeleteFinished(ctx, cutoff, limit)
+		}); err != nil {
+			return err
+		}
+		// Deleting accounts queues webhooks, which are sent right after.
+		if report.DeletedAccounts, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.accountService.DeleteDue(ctx, limit)
+		}); err != nil {
+			return err
+		}
+		if report.WebhooksAttempted, err = s.deleteInBatches(ctx, func(limit int) (int64, error) {
+			return s.webhookService.DeliverDue(ctx, limit)
+		}); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, ran, err
+	}
+	return report, ran, nil
+}
+
+func (s *JanitorService) deleteInBatches(ctx context.Context, deleteBatch func(limit int) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := deleteBatch(s.config.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(s.config.BatchSize) {
+			return total, nil
+		}
+	}
+}
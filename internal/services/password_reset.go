@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/mail"
+	"gt/internal/repository"
+	"gt/internal/security"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset link")
+
+const passwordResetTTL = time.Hour
+
+type PasswordResetService struct {
+	userRepo       *repository.UserRepository
+	resetRepo      *repository.PasswordResetRepository
+	sessionRepo    *repository.SessionRepository
+	gameLoginRepo  *repository.GameLoginRepository
+	mailer         mail.Sender
+	limiter        *AttemptLimiter
+	passwordPolicy PasswordPolicy
+	baseURL        string
+}
+
+func NewPasswordResetService(
+	userRepo *repository.UserRepository,
+	resetRepo *repository.PasswordResetRepository,
+	sessionRepo *repository.SessionRepository,
+	gameLoginRepo *repository.GameLoginRepository,
+	mailer mail.Sender,
+	limiter *AttemptLimiter,
+	passwordPolicy PasswordPolicy,
+	baseURL string,
+) *PasswordResetService {
+	return &PasswordResetService{
+		userRepo:       userRepo,
+		resetRepo:      resetRepo,
+		sessionRepo:    sessionRepo,
+		gameLoginRepo:  gameLoginRepo,
+		mailer:         mailer,
+		limiter:        limiter,
+		passwordPolicy: passwordPolicy,
+		baseURL:        baseURL,
+	}
+}
+
+type RequestResetRequest struct {
+	Email string
+	IP    string
+}
+
+// RequestReset mails a reset link to the account registered with the email.
+// It succeeds whether or not such an account exists, so callers can't
+// use it to probe for addresses.
+func (s *PasswordResetService) RequestReset(ctx context.Context, req RequestResetRequest) error {
+	email := strings.TrimSpace(req.Email)
+	keys := []string{"email:" + strings.ToLower(email), "ip:" + req.IP}
+	if err := s.limiter.Check(ctx, keys...); err != nil {
+		return err
+	}
+	// Every request counts against the limit, not just failures.
+	if err := s.limiter.RecordFailure(ctx, keys...); err != nil {
+		return err
+	}
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || user == nil {
+		return err
+	}
+	// Mail is sent in the background so the response takes the same time
+	// whether or not an account matched.
+	go func() {
+		if err := s.sendReset(context.WithoutCancel(ctx), user); err != nil {
+			log.Printf("failed to send password reset to user %s: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+func (s *PasswordResetService) sendReset(ctx context.Context, user *repository.User) error {
+	token := security.GenerateToken()
+	hashedToken, err := security.HashPassword(token)
+	if err != nil {
+		return err
+	}
+	reset, err := s.resetRepo.Create(ctx, &repository.CreatePasswordResetRequest{
+		UserID:    user.ID,
+		Token:     hashedToken,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+	link := s.baseURL + "/reset-password?" + url.Values{"token": []string{reset.ID + "." + token}}.Encode()
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Someone asked to reset the password of your account. Open the link below to choose a new one:\n\n" +
+			link + "\n\n" +
+			"The link expires in 1 hour. If you didn't ask for this, you can ignore this email.\n",
+	})
+}
+
+// CheckResetToken returns the pending reset the token belongs to.
+func (s *PasswordResetService) CheckResetToken(ctx context.Context, token string) (*repository.PasswordReset, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidResetToken
+	}
+	reset, err := s.resetRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if reset == nil || reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+	if !security.CheckPasswordHash(secret, reset.Token) {
+		return nil, ErrInvalidResetToken
+	}
+	return reset, nil
+}
+
+// ResetPassword sets a new password using a reset token. All of the user's
+// sessions and game logins are invalidated, as are any other pending resets.
+func (s *PasswordResetService) ResetPassword(ctx context.Context, token string, password string) error {
+	reset, err := s.CheckResetToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if message := s.passwordPolicy.Check(password, reset.User.Username, reset.User.Email); message != "" {
+		return &PasswordPolicyError{Message: message}
+	}
+	now := time.Now()
+	used, err := s.resetRepo.MarkUsed(ctx, reset.ID, now)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidResetToken
+	}
+	hashed, err := security.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, reset.UserID, hashed); err != nil {
+		return err
+	}
+	if err := s.resetRepo.MarkAllUsedByUserID(ctx, reset.UserID, now); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.DeleteAllByUserID(ctx, reset.UserID); err != nil {
+		return err
+	}
+	return s.gameLoginRepo.RevokeAllByUserID(ctx, reset.UserID, now)
+}
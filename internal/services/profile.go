@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/repository"
+)
+
+var (
+	ErrProfileNotFound          = errors.New("profile not found")
+	ErrInvalidProfileVisibility = errors.New("invalid profile visibility")
+)
+
+// profileRecentLimit is how many of the latest unlocks a profile lists as
+// recent activity.
+const profileRecentLimit = 10
+
+// Profile is a user's public profile as seen by a particular viewer.
+type Profile struct {
+	User *repository.User
+	// Friendship is the viewer's friendship with the user. It is
+	// FriendshipNone for anonymous viewers and the user themselves.
+	Friendship FriendshipStatus
+	// Hidden is set when the user's privacy settings don't let the viewer
+	// see the profile. Only User and Friendship are filled in then.
+	Hidden      bool
+	Games       []*ProfileGame
+	TotalPoints int
+	// Recent holds the latest unlocks across all games, newest first.
+	Recent []*repository.Achievement
+}
+
+type ProfileGame struct {
+	Game         *repository.Game
+	Achievements []*repository.Achievement
+	Points       int
+}
+
+type ProfileService struct {
+	userRepo        *repository.UserRepository
+	achievementRepo *repository.AchievementRepository
+	friendService   *FriendService
+}
+
+func NewProfileService(userRepo *repository.UserRepository, achievementRepo *repository.AchievementRepository, friendService *FriendService) *ProfileService {
+	return &ProfileService{userRepo: userRepo, achievementRepo: achievementRepo, friendService: friendService}
+}
+
+// GetProfile returns the profile of username as viewer sees it. viewer is nil
+// for anonymous visitors.
+func (s *ProfileService) GetProfile(ctx context.Context, viewer *repository.User, username string) (*Profile, error) {
+	user, err := s.userRepo.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrProfileNotFound
+	}
+	profile := &Profile{User: user}
+	own := viewer != nil && viewer.ID == user.ID
+	if viewer != nil && !own {
+		profile.Friendship, err = s.friendService.Status(ctx, viewer.ID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case own, user.ProfileVisibility == repository.ProfileVisibilityPublic:
+	case user.ProfileVisibility == repository.ProfileVisibilityFriends && profile.Friendship == FriendshipFriends:
+	default:
+		profile.Hidden = true
+		return profile, nil
+	}
+
+	// Achievements come newest first, so games are ordered by their latest
+	// unlock.
+	achievements, err := s.achievementRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	games := map[string]*ProfileGame{}
+	for _, achievement := range achievements {
+		game := achievement.Definition.Game
+		profileGame, ok := games[game.ID]
+		if !ok {
+			profileGame = &ProfileGame{Game: game}
+			games[game.ID] = profileGame
+			profile.Games = append(profile.Games, profileGame)
+		}
+		profileGame.Achievements = append(profileGame.Achievements, achievement)
+		profileGame.Points += achievement.Definition.Points
+		profile.TotalPoints += achievement.Definition.Points
+	}
+	profile.Recent = achievements[:min(len(achievements), profileRecentLimit)]
+	return profile, nil
+}
+
+func (s *ProfileService) UpdateVisibility(ctx context.Context, user *repository.User, visibility repository.ProfileVisibility) error {
+	switch visibility {
+	case repository.ProfileVisibilityPublic, repository.ProfileVisibilityFriends, repository.ProfileVisibilityPrivate:
+	default:
+		return ErrInvalidProfileVisibility
+	}
+	if err := s.userRepo.UpdateProfileVisibility(ctx, user.ID, visibility); err != nil {
+		return err
+	}
+	user.ProfileVisibility = visibility
+	return nil
+}
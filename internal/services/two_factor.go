@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gt/internal/repository"
+	"gt/internal/security"
+	"time"
+)
+
+var (
+	ErrInvalidTOTPCode     = errors.New("invalid authentication code")
+	ErrTOTPNotEnrolling    = errors.New("two-factor authentication setup has not been started")
+	ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrInvalidSecondFactor = errors.New("invalid authentication or recovery code")
+)
+
+const (
+	totpIssuer        = "GT"
+	recoveryCodeCount = 10
+)
+
+type TwoFactorService struct {
+	userRepo         *repository.UserRepository
+	recoveryCodeRepo *repository.RecoveryCodeRepository
+}
+
+func NewTwoFactorService(userRepo *repository.UserRepository, recoveryCodeRepo *repository.RecoveryCodeRepository) *TwoFactorService {
+	return &TwoFactorService{userRepo: userRepo, recoveryCodeRepo: recoveryCodeRepo}
+}
+
+// BeginEnrollment gives the user a new TOTP secret to add to their
+// authenticator app. TOTP stays off until Enable confirms a code.
+func (s *TwoFactorService) BeginEnrollment(ctx context.Context, user *repository.User) error {
+	if user.TOTPEnabled {
+		return ErrTOTPAlreadyEnabled
+	}
+	user.TOTPSecret = security.GenerateTOTPSecret()
+	return s.userRepo.SetTOTPSecret(ctx, user.ID, user.TOTPSecret)
+}
+
+// EnrollmentURI returns the otpauth:// URI for the secret being enrolled.
+func (s *TwoFactorService) EnrollmentURI(user *repository.User) string {
+	return security.TOTPURI(totpIssuer, user.Username, user.TOTPSecret)
+}
+
+// Enable turns TOTP on once the user proves their app generates valid codes,
+// and returns a fresh set of recovery codes to show once.
+func (s *TwoFactorService) Enable(ctx context.Context, user *repository.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolling
+	}
+	step, ok := security.MatchTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	if err := s.userRepo.EnableTOTP(ctx, user.ID, step); err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	return s.newRecoveryCodes(ctx, user.ID)
+}
+
+// Disable turns TOTP off. The password is asked for again so a hijacked
+// session alone can't remove the second factor.
+func (s *TwoFactorService) Disable(ctx context.Context, user *repository.User, password string) error {
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if !security.CheckPasswordHash(password, user.Password) {
+		return ErrInvalidCredentials
+	}
+	if err := s.userRepo.DisableTOTP(ctx, user.ID); err != nil {
+		return err
+	}
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	return s.recoveryCodeRepo.DeleteByUserID(ctx, user.ID)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, invalidating the
+// old ones. Like Disable it asks for the password again.
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, user *repository.User, password string) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	if !security.CheckPasswordHash(password, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+	return s.newRecoveryCodes(ctx, user.ID)
+}
+
+// newRecoveryCodes stores a new set of recovery codes and returns them in
+// plain text. Only their hashes are kept.
+func (s *TwoFactorService) newRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashed := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = security.GenerateRecoveryCode()
+		h, err := security.HashPassword(codes[i])
+		if err != nil {
+			return nil, err
+		}
+		hashed[i] = h
+	}
+	if err := s.recoveryCodeRepo.Replace(ctx, userID, hashed); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *TwoFactorService) CountRecoveryCodes(ctx context.Context, userID string) (int64, error) {
+	return s.recoveryCodeRepo.CountUnusedByUserID(ctx, userID)
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused
+// recovery code, consuming whichever was used.
+func (s *TwoFactorService) VerifySecondFactor(ctx context.Context, user *repository.User, code string) error {
+	if step, ok := security.MatchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		advanced, err := s.userRepo.AdvanceTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			return ErrInvalidSecondFactor
+		}
+		return nil
+	}
+	recoveryCode, ok := security.NormalizeRecoveryCode(code)
+	if !ok {
+		return ErrInvalidSecondFactor
+	}
+	codes, err := s.recoveryCodeRepo.GetUnusedByUserID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, c := range codes {
+		if !security.CheckPasswordHash(recoveryCode, c.Code) {
+			continue
+		}
+		used, err := s.recoveryCodeRepo.MarkUsed(ctx, c.ID, time.Now())
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidSecondFactor
+		}
+		return nil
+	}
+	return ErrInvalidSecondFactor
+}
@@ -2,17 +2,178 @@ package services
 
 import (
 	"context"
+	"errors"
 	"gt/internal/repository"
+	"gt/internal/security"
+	"strings"
+	"time"
 )
 
+var (
+	ErrUsernameTaken     = errors.New("username is already taken")
+	ErrUsernameUnchanged = errors.New("username is unchanged")
+	ErrEmailUnchanged    = errors.New("email is unchanged")
+)
+
+// UsernameCooldownError is returned when the user renamed themselves too
+// recently to do it again.
+type UsernameCooldownError struct {
+	Until time.Time
+}
+
+func (e *UsernameCooldownError) Error() string {
+	return "username was changed recently"
+}
+
+type UserConfig struct {
+	// UsernameCooldown is how long a user has to wait between username
+	// changes.
+	UsernameCooldown time.Duration
+	// UsernameReservation is how long a username stays reserved for its
+	// previous owner after a change, so it can't be squatted.
+	UsernameReservation time.Duration
+}
+
 type UserService struct {
-	userRepo *repository.UserRepository
+	userRepo                *repository.UserRepository
+	sessionRepo             *repository.SessionRepository
+	usernameReservationRepo *repository.UsernameReservationRepository
+	verificationService     *EmailVerificationService
+	passwordPolicy          PasswordPolicy
+	config                  UserConfig
 }
 
-func NewUserService(userRepo *repository.UserRepository) *UserService {
-	return &UserService{userRepo: userRepo}
+func NewUserService(
+	userRepo *repository.UserRepository,
+	sessionRepo *repository.SessionRepository,
+	usernameReservationRepo *repository.UsernameReservationRepository,
+	verificationService *EmailVerificationService,
+	passwordPolicy PasswordPolicy,
+	config UserConfig,
+) *UserService {
+	return &UserService{
+		userRepo:                userRepo,
+		sessionRepo:             sessionRepo,
+		usernameReservationRepo: usernameReservationRepo,
+		verificationService:     verificationService,
+		passwordPolicy:          passwordPolicy,
+		config:                  config,
+	}
 }
 
 func (s *UserService) GetUserByID(ctx context.Context, id string) (*repository.User, error) {
 	return s.userRepo.GetByID(ctx, id)
 }
+
+func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*repository.User, error) {
+	return s.userRepo.GetByUsername(ctx, username)
+}
+
+// UsernameCooldownUntil returns when the user may change their username
+// again, or nil if they already can.
+func (s *UserService) UsernameCooldownUntil(user *repository.User) *time.Time {
+	if user.UsernameChangedAt == nil {
+		return nil
+	}
+	until := user.UsernameChangedAt.Add(s.config.UsernameCooldown)
+	if !time.Now().Before(until) {
+		return nil
+	}
+	return &until
+}
+
+// ChangeUsername renames the user. The old name stays reserved for them for
+// a while, and the user can't rename again until the cooldown has passed.
+func (s *UserService) ChangeUsername(ctx context.Context, user *repository.User, username string) error {
+	username = NormalizeUsername(username)
+	if username == user.Username {
+		return ErrUsernameUnchanged
+	}
+	if until := s.UsernameCooldownUntil(user); until != nil {
+		return &UsernameCooldownError{Until: *until}
+	}
+	if message := ValidateUsername(username); message != "" {
+		return &ValidationError{Message: message}
+	}
+	taken, err := usernameTaken(ctx, s.userRepo, s.usernameReservationRepo, username, user.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrUsernameTaken
+	}
+	now := time.Now()
+	err = s.userRepo.ChangeUsername(ctx, user.ID, user.Username, username, now, now.Add(s.config.UsernameReservation))
+	if err != nil {
+		return err
+	}
+	user.Username = username
+	user.UsernameChangedAt = &now
+	return nil
+}
+
+// RequestEmailChange asks for the password again and mails a confirmation
+// link to the new address. The email only changes once the link is opened.
+func (s *UserService) RequestEmailChange(ctx context.Context, user *repository.User, password string, email string) error {
+	if !security.CheckPasswordHash(password, user.Password) {
+		return ErrInvalidCredentials
+	}
+	email = NormalizeEmail(email)
+	if strings.EqualFold(email, user.Email) {
+		return ErrEmailUnchanged
+	}
+	if message := ValidateEmail(email); message != "" {
+		return &ValidationError{Message: message}
+	}
+	existing, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrEmailTaken
+	}
+	return s.verificationService.SendEmailChange(ctx, user, email)
+}
+
+// ChangePassword replaces the password after checking the current one, and
+// signs out every session but the one making the change.
+func (s *UserService) ChangePassword(ctx context.Context, user *repository.User, sessionID string, currentPassword string, newPassword string) error {
+	if !security.CheckPasswordHash(currentPassword, user.Password) {
+		return ErrInvalidCredentials
+	}
+	if message := s.passwordPolicy.Check(newPassword, user.Username, user.Email); message != "" {
+		return &PasswordPolicyError{Message: message}
+	}
+	hashed, err := security.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, hashed); err != nil {
+		return err
+	}
+	user.Password = hashed
+	return s.sessionRepo.DeleteOthersByUserID(ctx, user.ID, sessionID)
+}
+
+// usernameTaken reports whether username belongs to, or is reserved for,
+// someone other than userID. userID is empty for new accounts.
+func usernameTaken(
+	ctx context.Context,
+	userRepo *repository.UserRepository,
+	usernameReservationRepo *repository.UsernameReservationRepository,
+	username string,
+	userID string,
+) (bool, error) {
+	existing, err := userRepo.GetByUsername(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil && existing.ID != userID {
+		return true, nil
+	}
+	reservation, err := usernameReservationRepo.GetActive(ctx, username, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return reservation != nil && reservation.UserID != userID, nil
+}
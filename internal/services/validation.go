@@ -0,0 +1,140 @@
+package services
+
+import (
+	"gt/internal/security"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Usernames are 3 to 32 ASCII letters, digits, dots, dashes and
+// underscores, starting and ending with a letter or digit.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{1,30}[A-Za-z0-9]$`)
+
+// reservedUsernames can't be registered because they collide with routes or
+// could be mistaken for staff accounts.
+var reservedUsernames = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"developer":     true,
+	"feed":          true,
+	"gt":            true,
+	"login":         true,
+	"logout":        true,
+	"moderator":     true,
+	"null":          true,
+	"profile":       true,
+	"root":          true,
+	"settings":      true,
+	"signup":        true,
+	"staff":         true,
+	"support":       true,
+	"system":        true,
+	"undefined":     true,
+}
+
+// NormalizeUsername applies NFKC normalization and trims surrounding spaces,
+// so lookalike forms such as full-width letters map to plain ASCII.
+func NormalizeUsername(username string) string {
+	return strings.TrimSpace(norm.NFKC.String(username))
+}
+
+// ValidateUsername returns why a normalized username isn't allowed, or an
+// empty string if it is.
+func ValidateUsername(username string) string {
+	switch {
+	case username == "":
+		return "Username is required"
+	case len(username) < 3 || len(username) > 32:
+		return "Username must be 3 to 32 characters long"
+	case !usernamePattern.MatchString(username):
+		return "Username may only contain letters, digits, dots, dashes and underscores, and must start and end with a letter or digit"
+	case reservedUsernames[strings.ToLower(username)]:
+		return "This username is reserved"
+	}
+	return ""
+}
+
+// NormalizeEmail trims the address and lowercases its domain, which is case
+// insensitive.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+	return email[:at+1] + strings.ToLower(email[at+1:])
+}
+
+// ValidateEmail returns why a normalized email address isn't acceptable, or
+// an empty string if it is.
+func ValidateEmail(email string) string {
+	if email == "" {
+		return "Email is required"
+	}
+	if len(email) > 254 {
+		return "Email is too long"
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || !strings.Contains(email[strings.LastIndexByte(email, '@'):], ".") {
+		return "Enter a valid email address"
+	}
+	return ""
+}
+
+// ValidationError is returned when user input fails one of the checks above.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int
+	// RejectBreached rejects passwords from the bundled list of common
+	// passwords seen in breaches.
+	RejectBreached bool
+}
+
+// bcrypt ignores everything past 72 bytes, so longer passwords are refused
+// rather than silently truncated.
+const maxPasswordBytes = 72
+
+// Check returns why password isn't acceptable, or an empty string if it is.
+// The user's other identifiers are passed so the password can't repeat them.
+func (p PasswordPolicy) Check(password string, identifiers ...string) string {
+	switch {
+	case password == "":
+		return "Password is required"
+	case utf8.RuneCountInString(password) < p.MinLength:
+		return "Password must be at least " + strconv.Itoa(p.MinLength) + " characters long"
+	case len(password) > maxPasswordBytes:
+		return "Password must be at most 72 bytes long"
+	case p.RejectBreached && security.IsBreachedPassword(password):
+		return "This password is too common. Please choose a different one"
+	}
+	for _, identifier := range identifiers {
+		if identifier != "" && strings.EqualFold(password, identifier) {
+			return "Password must not be the same as your username or email"
+		}
+	}
+	return ""
+}
+
+// PasswordPolicyError is returned when a new password is refused by the
+// password policy.
+type PasswordPolicyError struct {
+	Message string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Message
+}
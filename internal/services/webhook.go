@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gt/internal/repository"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("webhook URL must be an https URL")
+
+	errWebhookAddressNotAllowed = errors.New("webhook address is not a public address")
+)
+
+const (
+	WebhookEventUserDeleted = "user.deleted"
+
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 10
+	webhookMaxDelay    = 6 * time.Hour
+)
+
+// validateWebhookURL accepts an empty URL, which turns webhooks off.
+func validateWebhookURL(rawURL string) error {
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return ErrInvalidWebhookURL
+	}
+	return nil
+}
+
+// webhookUserDeleted is the payload of WebhookEventUserDeleted. Studios should
+// drop any data they keep about the player.
+type webhookUserDeleted struct {
+	Event     string    `json:"event"`
+	UserID    string    `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func userDeletedDeliveries(games []*repository.Game, userID string, at time.Time) ([]*repository.CreateWebhookDeliveryRequest, error) {
+	payload, err := json.Marshal(webhookUserDeleted{
+		Event:     WebhookEventUserDeleted,
+		UserID:    userID,
+		DeletedAt: at.UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]*repository.CreateWebhookDeliveryRequest, len(games))
+	for i, game := range games {
+		deliveries[i] = &repository.CreateWebhookDeliveryRequest{
+			GameID:  game.ID,
+			Event:   WebhookEventUserDeleted,
+			Payload: string(payload),
+		}
+	}
+	return deliveries, nil
+}
+
+// WebhookSignature signs a delivery the way studios are told to verify it:
+// hex HMAC-SHA256 over the timestamp, a dot and the body.
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type WebhookService struct {
+	deliveryRepo *repository.WebhookDeliveryRepository
+	client       *http.Client
+}
+
+func NewWebhookService(deliveryRepo *repository.WebhookDeliveryRepository) *WebhookService {
+	// Webhook URLs are chosen by developers, so requests are only allowed to
+	// reach public addresses, including after redirects.
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return errWebhookAddressNotAllowed
+			}
+			return nil
+		},
+	}
+	return &WebhookService{
+		deliveryRepo: deliveryRepo,
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+	}
+}
+
+// DeliverDue attempts up to limit pending deliveries and returns how many
+// were attempted. Failed deliveries are retried with exponential backoff
+// until webhookMaxAttempts is reached.
+func (s *WebhookService) DeliverDue(ctx context.Context, limit int) (int64, error) {
+	deliveries, err := s.deliveryRepo.GetDue(ctx, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range deliveries {
+		deliverErr := s.deliver(ctx, delivery)
+		now := time.Now()
+		switch {
+		case deliverErr == nil:
+			err = s.deliveryRepo.MarkDelivered(ctx, delivery.ID, now)
+		case delivery.Attempts+1 >= webhookMaxAttempts:
+			log.Printf("webhook: giving up on delivery %s to game %s: %v", delivery.ID, delivery.GameID, deliverErr)
+			err = s.deliveryRepo.MarkFailed(ctx, delivery.ID, now)
+		default:
+			err = s.deliveryRepo.MarkAttemptFailed(ctx, delivery.ID, now.Add(webhookRetryDelay(delivery.Attempts)))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(deliveries)), nil
+}
+
+// webhookRetryDelay doubles from one minute with every failed attempt.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := time.Minute << attempts
+	if delay <= 0 || delay > webhookMaxDelay {
+		return webhookMaxDelay
+	}
+	return delay
+}
+
+func (s *WebhookService) deliver(ctx context.Context, delivery *repository.WebhookDelivery) error {
+	// The studio may have removed the URL since the event was queued.
+	if delivery.Game.WebhookURL == "" {
+		return nil
+	}
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Game.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GT-Event", delivery.Event)
+	req.Header.Set("X-GT-Delivery", delivery.ID)
+	req.Header.Set("X-GT-Timestamp", timestamp)
+	req.Header.Set("X-GT-Signature", WebhookSignature(delivery.Game.WebhookSecret, timestamp, body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Package storage keeps uploaded files such as avatars behind a small
+// interface, so where they live can be changed without touching callers.
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// CacheControl is sent with every stored blob. Keys are derived from the
+// content they hold (see ContentKey), so a URL never changes meaning and
+// clients may cache it for as long as they like.
+const CacheControl = "public, max-age=31536000, immutable"
+
+// Storage stores blobs under slash-separated keys and knows the public URL
+// each one is served from. A key is written once; storing different content
+// under an existing key breaks the caching promised by CacheControl.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Delete removes the blob. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// ContentKey returns the key data is stored under: the hex SHA-256 of data
+// between prefix and ext, e.g. "icons/3a7bd3….png".
+func ContentKey(prefix string, data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	return prefix + hex.EncodeToString(sum[:]) + ext
+}
+
+// validateKey rejects keys that could escape the storage root.
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -18,5 +18,5 @@ func parseAuthenticatedTemplate(files ...string) *template.Template {
 	all = append(all, baseTemplates...)
 	all = append(all, authenticated...)
 	all = append(all, files...)
-	return template.Must(template.ParseFiles(all...))
+	return parseFiles(all...)
 }
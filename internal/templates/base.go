@@ -1,14 +1,44 @@
 package templates
 
-import "html/template"
+import (
+	"gt/internal/middleware"
+	"html/template"
+	"net/http"
+)
 
 var baseTemplates = []string{
 	"web/templates/layout/base.html",
 }
 
+// funcs holds placeholders for functions that Render binds per request.
+var funcs = template.FuncMap{
+	"csrfField": func() template.HTML { return "" },
+}
+
+func parseFiles(files ...string) *template.Template {
+	return template.Must(template.New("base.html").Funcs(funcs).ParseFiles(files...))
+}
+
 func parseTemplate(files ...string) *template.Template {
 	all := make([]string, 0, len(baseTemplates)+len(files))
 	all = append(all, baseTemplates...)
 	all = append(all, files...)
-	return template.Must(template.ParseFiles(all...))
+	return parseFiles(all...)
+}
+
+// Render executes tmpl with csrfField bound to a hidden input carrying the
+// request's CSRF token, so every form can include it with {{ csrfField }}.
+// Multipart forms must include it before any other field.
+func Render(w http.ResponseWriter, r *http.Request, tmpl *template.Template, data any) error {
+	t, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	token := middleware.CSRFTokenFromContext(r.Context())
+	t.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + middleware.CSRFFieldName + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
+	})
+	return t.Execute(w, data)
 }
@@ -0,0 +1,26 @@
+package templates
+
+import "gt/internal/repository"
+
+type DeveloperData struct {
+	AuthenticatedData
+	Games []*repository.Game
+	Error string
+}
+
+var DeveloperTemplate = parseAuthenticatedTemplate(
+	"web/templates/page/developer/index.html",
+)
+
+type DeveloperGameData struct {
+	AuthenticatedData
+	Game          *repository.Game
+	Definitions   []*repository.AchievementDefinition
+	LinkedPlayers int64
+	ClientSecret  string
+	Error         string
+}
+
+var DeveloperGameTemplate = parseAuthenticatedTemplate(
+	"web/templates/page/developer/game.html",
+)
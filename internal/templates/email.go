@@ -0,0 +1,12 @@
+package templates
+
+type VerifyEmailData struct {
+	Verified bool
+	Sent     bool
+	Email    string
+	Error    string
+}
+
+var VerifyEmailTemplate = parseTemplate(
+	"web/templates/page/verify_email.html",
+)
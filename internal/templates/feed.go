@@ -3,14 +3,39 @@ package templates
 import "time"
 
 type AchievementData struct {
-	Name      string
-	ImageURL  string
-	CreatedAt time.Time
+	Name        string
+	Description string
+	ImageURL    string
+	Points      int
+	CreatedAt   time.Time
+}
+
+type GameAchievementsData struct {
+	Name         string
+	Achievements []AchievementData
+}
+
+// AchievementProgressData is a progress achievement the user has started but
+// not unlocked yet.
+type AchievementProgressData struct {
+	GameName    string
+	Name        string
+	Description string
+	ImageURL    string
+	Points      int
+	Progress    int
+	Target      int
+}
+
+// Percent is the share of the target reached, rounded down.
+func (d AchievementProgressData) Percent() int {
+	return min(d.Progress*100/d.Target, 100)
 }
 
 type FeedData struct {
 	AuthenticatedData
-	Achievements []AchievementData
+	InProgress []AchievementProgressData
+	Games      []GameAchievementsData
 }
 
 var FeedTemplate = parseAuthenticatedTemplate(
@@ -5,6 +5,7 @@ import "gt/internal/repository"
 type GameData struct {
 	GameLoginRequest *repository.GameLoginRequest
 	User             *repository.User
+	Denied           bool
 	Error            string
 }
 
@@ -15,3 +16,12 @@ var GameLoginTemplate = parseTemplate(
 var GameOKTemplate = parseTemplate(
 	"web/templates/page/game/ok.html",
 )
+
+type GameActivateData struct {
+	UserCode string
+	Error    string
+}
+
+var GameActivateTemplate = parseTemplate(
+	"web/templates/page/game/activate.html",
+)
@@ -3,6 +3,9 @@ package templates
 type LoginData struct {
 	Error    string
 	Redirect string
+	// Challenge is set when the password was accepted and the second factor
+	// is being asked for.
+	Challenge string
 }
 
 var LoginTemplate = parseTemplate(
@@ -11,8 +14,31 @@ var LoginTemplate = parseTemplate(
 
 type SignupData struct {
 	Error string
+	// Errors maps a form field to the problem found with it.
+	Errors   map[string]string
+	Username string
+	Email    string
 }
 
 var SignupTemplate = parseTemplate(
 	"web/templates/page/signup.html",
 )
+
+type ForgotPasswordData struct {
+	Sent  bool
+	Error string
+}
+
+var ForgotPasswordTemplate = parseTemplate(
+	"web/templates/page/forgot_password.html",
+)
+
+type ResetPasswordData struct {
+	Token string
+	Done  bool
+	Error string
+}
+
+var ResetPasswordTemplate = parseTemplate(
+	"web/templates/page/reset_password.html",
+)
@@ -0,0 +1,46 @@
+package templates
+
+import (
+	"gt/internal/repository"
+	"time"
+)
+
+type ProfileGameData struct {
+	Name         string
+	IconURL      string
+	Points       int
+	Achievements []AchievementData
+}
+
+type RecentAchievementData struct {
+	Game       string
+	Name       string
+	UnlockedAt time.Time
+}
+
+type ProfileData struct {
+	// User is the signed in viewer, or nil for anonymous visitors.
+	User      *repository.User
+	Username  string
+	AvatarURL string
+	Own       bool
+	// Hidden is set when the profile's privacy settings hide it from the
+	// viewer.
+	Hidden      bool
+	TotalPoints int
+	Games       []ProfileGameData
+	Recent      []RecentAchievementData
+	// The viewer's friendship with the profile owner.
+	IsFriend        bool
+	RequestSent     bool
+	RequestReceived bool
+	// Only filled in on the viewer's own profile.
+	Friends          []string
+	IncomingRequests []string
+}
+
+var ProfileTemplate = parseTemplate(
+	"web/templates/partial/nav.html",
+	"web/templates/partial/achievement.html",
+	"web/templates/page/profile.html",
+)
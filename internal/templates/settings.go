@@ -0,0 +1,72 @@
+package templates
+
+import (
+	"gt/internal/repository"
+	"html/template"
+	"time"
+)
+
+func parseSettingsTemplate(files ...string) *template.Template {
+	return parseAuthenticatedTemplate(append([]string{"web/templates/partial/settings_nav.html"}, files...)...)
+}
+
+type SettingsAccountData struct {
+	AuthenticatedData
+	// UsernameCooldownUntil is set while the user can't change their
+	// username yet.
+	UsernameCooldownUntil *time.Time
+	AvatarURL             string
+	// DeletionGracePeriod describes how long a deletion can be cancelled,
+	// such as "14 days".
+	DeletionGracePeriod string
+	Notice              string
+	Error               string
+}
+
+var SettingsAccountTemplate = parseSettingsTemplate(
+	"web/templates/page/settings/account.html",
+)
+
+type SettingsGamesData struct {
+	AuthenticatedData
+	GameLogins []*repository.GameLogin
+}
+
+var SettingsGamesTemplate = parseSettingsTemplate(
+	"web/templates/page/settings/games.html",
+)
+
+type SessionData struct {
+	ID         string
+	Browser    string
+	OS         string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Current    bool
+}
+
+type SettingsSessionsData struct {
+	AuthenticatedData
+	Sessions []SessionData
+}
+
+var SettingsSessionsTemplate = parseSettingsTemplate(
+	"web/templates/page/settings/sessions.html",
+)
+
+type SettingsSecurityData struct {
+	AuthenticatedData
+	TOTPEnabled bool
+	// Enrolling is set while a secret has been generated but not confirmed.
+	Enrolling              bool
+	Secret                 string
+	QRCode                 template.HTML
+	RecoveryCodes          []string
+	RemainingRecoveryCodes int64
+	Error                  string
+}
+
+var SettingsSecurityTemplate = parseSettingsTemplate(
+	"web/templates/page/settings/security.html",
+)
@@ -0,0 +1,61 @@
+package useragent
+
+import "strings"
+
+type UserAgent struct {
+	Browser string
+	OS      string
+}
+
+type rule struct {
+	token string
+	name  string
+}
+
+// Order matters: many browsers include the tokens of the ones they derive from
+// (Edge and Opera mention Chrome, Chrome mentions Safari).
+var browserRules = []rule{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"python-requests/", "Python Requests"},
+}
+
+var osRules = []rule{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"PlayStation", "PlayStation"},
+	{"Xbox", "Xbox"},
+	{"Nintendo", "Nintendo"},
+	{"Linux", "Linux"},
+}
+
+// Parse extracts a human readable browser and OS name from a User-Agent
+// header. Unknown values are reported as "Unknown".
+func Parse(ua string) UserAgent {
+	return UserAgent{
+		Browser: match(ua, browserRules),
+		OS:      match(ua, osRules),
+	}
+}
+
+func match(ua string, rules []rule) string {
+	for _, r := range rules {
+		if strings.Contains(ua, r.token) {
+			return r.name
+		}
+	}
+	return "Unknown"
+}